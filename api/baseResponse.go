@@ -1,5 +1,10 @@
 package api
 
+import (
+	"bytes"
+	"fmt"
+)
+
 type BaseResponse struct {
 	Ok      bool        `json:"ok"`
 	Index   string      `json:"_index,omitempty"`
@@ -29,6 +34,28 @@ type Explaination struct {
 	Details     []Explaination `json:"details,omitempty"`
 }
 
+// Renders the explanation tree as indented text, one node per line in the style of a
+// lucene explain dump
+//
+//    0.7554128 = (MATCH) weight(actor:kimchy in 12), product of:
+//      0.99999994 = queryWeight(actor:kimchy), product of:
+//        ...
+func (e Explaination) String() string {
+	var buf bytes.Buffer
+	e.format(&buf, 0)
+	return buf.String()
+}
+
+func (e *Explaination) format(buf *bytes.Buffer, depth int) {
+	for i := 0; i < depth; i++ {
+		buf.WriteString("  ")
+	}
+	fmt.Fprintf(buf, "%v = %s\n", e.Value, e.Description)
+	for i := range e.Details {
+		e.Details[i].format(buf, depth+1)
+	}
+}
+
 func Pretty(pretty bool) string {
 	prettyString := ""
 	if pretty == true {
@@ -2,24 +2,34 @@ package core
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	u "github.com/araddon/gou"
 	"github.com/mattbaird/elastigo/api"
+	"hash/crc32"
 	"io"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
+	// The defaults of new indexors, see BulkConfig
 	// Max buffer size in bytes before flushing to elasticsearch
 	BulkMaxBuffer = 1048576
 	// Max number of Docs to hold in buffer before forcing flush
 	BulkMaxDocs = 100
 	// Max delay before forcing a flush to Elasticearch
 	BulkDelaySeconds = 5
-	// Keep a running total of errors seen, since it is in the background
+	// Keep a running total of errors seen, since it is in the background, read it
+	// with atomic.LoadUint64
 	BulkErrorCt uint64
 
 	// There is one Global Bulk Indexor for convenience
@@ -51,7 +61,8 @@ func BulkIndexorGlobalRun(maxConns int, done chan bool) {
 type BulkIndexor struct {
 
 	// We are creating a variable defining the func responsible for sending
-	// to allow a mock sendor for test purposes
+	// to allow a mock sendor for test purposes.   A sendor set before Run is given
+	// its own copy of the bulk data, which it may keep.
 	BulkSendor func(*bytes.Buffer) error
 
 	// If we encounter an error in sending, we are going to retry for this long
@@ -62,30 +73,146 @@ type BulkIndexor struct {
 	// channel for getting errors
 	ErrorChannel chan *ErrorBuffer
 
+	// Optional hooks, called from the sending goroutines so they must be safe for
+	// concurrent use.   BeforeSend gets the bulk data of each request, AfterSend its
+	// outcome, and OnItemFailure each item that failed for good, with its bulk data.
+	// The data is reused once they return, so it must be copied to be kept.
+	BeforeSend    func(data []byte)
+	AfterSend     func(result BulkSendResult, took time.Duration)
+	OnItemFailure func(item BulkItemResult, data []byte)
+
+	// Optional spool for buffers that could not be delivered, which are sent again
+	// once the cluster is back, it must be set before Run
+	Spool *BulkSpool
+
+	// Keep the writes to each document in order, it must be set before Run.   Docs
+	// are split by a hash of their index and id into maxConns lanes, each with its own
+	// buffer and sendor, so a document is never in two requests at once.   A lane
+	// whose requests are slow only holds up the docs going to it.
+	Ordered bool
+
 	// channel for sending to background indexor
-	bulkChannel chan []byte
+	bulkChannel chan bulkDoc
 
 	// shutdown channel
 	shutdownChan chan bool
+	// closed by Close: to stop accepting docs and the ticker, if the wait timed out,
+	// and when everything has been sent
+	closing  chan bool
+	aborted  chan bool
+	finished chan bool
+	closeMu  sync.RWMutex
+	closed   bool
+	running  bool
+	// Index calls in progress, bulkChannel is only closed once they are done
+	adding sync.WaitGroup
+	// closed as the doc and timer goroutines end
+	docDone   chan bool
+	timerDone chan bool
+	sendors   sync.WaitGroup
+	// Number of docs that failed for good, or were dropped by an aborted Close
+	failedCt uint64
+	statsMu  sync.Mutex
+	stats    BulkStats
 
-	// buffers
-	sendBuf chan *bytes.Buffer
-	buf     *bytes.Buffer
-	// Number of documents we have send through so far on this session
-	docCt int
+	// adaptive sizing, the docs per batch (0 when not adaptive) and the requests
+	// allowed in flight, not under mu as senders wait on them
+	adaptDocs int64
+	flowMu    sync.Mutex
+	flowCond  *sync.Cond
+	adaptive  *BulkAdaptive
+	conns     int
+	inFlight  int
+	// bytes added but not yet sent, limited to maxInFlight (0 for no limit), with
+	// flushNow to send partly filled buffers when callers wait for room
+	inFlightBytes int64
+	maxInFlight   int64
+	bytesCond     *sync.Cond
+	bytesWaiters  int
+	flushNow      chan bool
+
+	// thresholds for sending the buffer, guarded by mu
+	config BulkConfig
+	// tells the timer the FlushInterval changed
+	resetTimer chan bool
+
+	// buffers, one lane unless Ordered
+	lanes    []*bulkLane
+	nextLane int
+	// signalled, with mu, as full buffers are queued in a lane or leave it
+	laneCond *sync.Cond
 	// Max number of http connections in flight at one time
 	maxConns int
 	// Was the last send induced by time?  or if not, by max docs/size?
 	lastSendorByTime bool
-	mu               sync.Mutex
+	// Is the BulkSendor one set by the caller, given copies of the pooled buffers
+	copySend bool
+	// Has shutdown sent the last buffers, so the lanes close their sendBuf once empty
+	stopped bool
+	mu      sync.Mutex
+}
+
+// A doc on its way to a lane, the key is its index/id, docs without one go to
+// the lane taking its turn when added
+type bulkDoc struct {
+	key  string
+	lane int
+	buf  *bytes.Buffer
+}
+
+// The buffer being filled, the full buffers queued under mu, and the channel
+// taking them to the lanes sendors.   Queueing never blocks, so a lane whose
+// sendors are stuck only holds up the docs going to it.
+type bulkLane struct {
+	sendBuf chan *bytes.Buffer
+	full    []*bytes.Buffer
+	buf     *bytes.Buffer
+	// Number of documents in buf
+	docCt int
+}
+
+func newBulkLane(size int) *bulkLane {
+	return &bulkLane{sendBuf: make(chan *bytes.Buffer, size), buf: getBulkBuffer()}
+}
+
+// Buffers reused for docs and requests, so the hot path does not allocate them
+var bulkBufferPool = sync.Pool{New: func() interface{} {
+	return new(bytes.Buffer)
+}}
+
+// Buffers that grew larger than this are left to the garbage collector rather
+// than kept in the pool
+const bulkPoolMaxBuffer = 16 << 20
+
+func getBulkBuffer() *bytes.Buffer {
+	buf := bulkBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBulkBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= bulkPoolMaxBuffer {
+		bulkBufferPool.Put(buf)
+	}
 }
 
 func NewBulkIndexor(maxConns int) *BulkIndexor {
-	b := BulkIndexor{sendBuf: make(chan *bytes.Buffer, maxConns)}
+	b := BulkIndexor{lanes: []*bulkLane{newBulkLane(maxConns)}}
 	b.lastSendorByTime = true
-	b.buf = new(bytes.Buffer)
 	b.maxConns = maxConns
-	b.bulkChannel = make(chan []byte, 100)
+	b.bulkChannel = make(chan bulkDoc, 100)
+	b.config = DefaultBulkConfig()
+	b.flowCond = sync.NewCond(&b.flowMu)
+	b.bytesCond = sync.NewCond(&b.flowMu)
+	b.flushNow = make(chan bool, 1)
+	b.laneCond = sync.NewCond(&b.mu)
+	b.conns = maxConns
+	b.resetTimer = make(chan bool, 1)
+	b.closing = make(chan bool)
+	b.aborted = make(chan bool)
+	b.finished = make(chan bool)
+	b.docDone = make(chan bool)
+	b.timerDone = make(chan bool)
 	return &b
 }
 
@@ -96,89 +223,430 @@ func NewBulkIndexor(maxConns int) *BulkIndexor {
 //   done := make(chan bool)
 //   BulkIndexorGlobalRun(100, done)
 func NewBulkIndexorErrors(maxConns, retrySeconds int) *BulkIndexor {
-	b := BulkIndexor{sendBuf: make(chan *bytes.Buffer, maxConns)}
-	b.lastSendorByTime = true
-	b.buf = new(bytes.Buffer)
-	b.maxConns = maxConns
+	b := NewBulkIndexor(maxConns)
 	b.RetryForSeconds = retrySeconds
-	b.bulkChannel = make(chan []byte, 100)
 	b.ErrorChannel = make(chan *ErrorBuffer, 20)
-	return &b
+	return b
 }
 
 // Starts this bulk Indexor running, this Run opens a go routine so is
-// Non blocking
+// Non blocking.   Sending on, or closing, @done is the same as Close without
+// a timeout.
 func (b *BulkIndexor) Run(done chan bool) {
-
+	b.closeMu.Lock()
+	defer b.closeMu.Unlock()
+	if b.running || b.closed {
+		return
+	}
+	b.running = true
+	b.copySend = b.BulkSendor != nil
+	if b.BulkSendor == nil {
+		b.BulkSendor = BulkSend
+	}
+	b.shutdownChan = done
+	if b.Ordered && b.maxConns > 1 {
+		lanes := make([]*bulkLane, b.maxConns)
+		for i := range lanes {
+			lanes[i] = newBulkLane(1)
+		}
+		b.mu.Lock()
+		b.lanes = lanes
+		b.mu.Unlock()
+	}
+	b.startHttpSendor()
+	b.startDocChannel()
+	b.startTimer()
+	b.startSpoolReplay()
+	go b.shutdown()
 	go func() {
-		if b.BulkSendor == nil {
-			b.BulkSendor = BulkSend
-		}
-		b.shutdownChan = done
-		b.startHttpSendor()
-		b.startDocChannel()
-		b.startTimer()
-		<-b.shutdownChan
+		select {
+		case <-b.shutdownChan:
+			b.Close(context.Background())
+		case <-b.closing:
+		}
 	}()
 }
 
+// Stop the indexor: no more documents are accepted, those already added are sent,
+// and once the in flight requests are done the ErrorChannel is closed.   It waits
+// until then, or until @ctx is done, when the unsent documents are dropped.   The
+// error is the number of docs that failed, or ctx.Err() and the number left unsent.
+// It may be called more than once, later calls wait like the first.
+//
+//   ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+//   defer cancel()
+//   if err := indexor.Close(ctx); err != nil {
+//     log.Println(err)
+//   }
+func (b *BulkIndexor) Close(ctx context.Context) error {
+	b.closeMu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.closing)
+		if !b.running {
+			// nothing to wait for
+			close(b.finished)
+		}
+	}
+	b.closeMu.Unlock()
+
+	select {
+	case <-b.finished:
+		if n := atomic.LoadUint64(&b.failedCt); n > 0 {
+			return fmt.Errorf("bulk indexor closed with %d failed docs", n)
+		}
+		if b.Spool != nil && b.Spool.Len() > 0 {
+			return fmt.Errorf("bulk indexor closed with %d buffers left in the spool", b.Spool.Len())
+		}
+		return nil
+	case <-ctx.Done():
+		b.closeMu.Lock()
+		select {
+		case <-b.aborted:
+		default:
+			close(b.aborted)
+		}
+		b.closeMu.Unlock()
+		// wake the sendors waiting to go in flight, and the callers waiting for room
+		b.flowMu.Lock()
+		b.flowCond.Broadcast()
+		b.bytesCond.Broadcast()
+		b.flowMu.Unlock()
+		b.mu.Lock()
+		unsent := b.bufferedDocs() + len(b.bulkChannel)
+		for _, lane := range b.lanes {
+			for _, buf := range lane.full {
+				if b.Spool == nil || b.Spool.Put(buf.Bytes()) != nil {
+					unsent += len(bulkItems(buf.Bytes()))
+				}
+				b.releaseBytes(buf.Len())
+				putBulkBuffer(buf)
+			}
+			lane.full = nil
+			for i := len(lane.sendBuf); i > 0; i-- {
+				select {
+				case buf := <-lane.sendBuf:
+					if b.Spool == nil || b.Spool.Put(buf.Bytes()) != nil {
+						unsent += len(bulkItems(buf.Bytes()))
+					}
+					b.releaseBytes(buf.Len())
+				default:
+				}
+			}
+		}
+		// wake the callers waiting for room in a lane
+		b.laneCond.Broadcast()
+		b.mu.Unlock()
+		return fmt.Errorf("bulk indexor close: %v, %d docs not sent, %d failed", ctx.Err(), unsent, atomic.LoadUint64(&b.failedCt))
+	}
+}
+
+// Once closing: wait for the docs being added, send what is buffered, and wait
+// for the sendors, then close the channels so all the goroutines end
+func (b *BulkIndexor) shutdown() {
+	<-b.closing
+	b.adding.Wait()
+	close(b.bulkChannel)
+	<-b.docDone
+	<-b.timerDone
+	b.mu.Lock()
+	for _, lane := range b.lanes {
+		if lane.docCt > 0 {
+			b.send(lane)
+		}
+	}
+	b.stopped = true
+	b.laneCond.Broadcast()
+	b.mu.Unlock()
+	b.sendors.Wait()
+	if b.ErrorChannel != nil {
+		close(b.ErrorChannel)
+	}
+	close(b.finished)
+}
+
 // Flush all current documents to ElasticSearch
 func (b *BulkIndexor) Flush() {
 	b.mu.Lock()
-	if b.docCt > 0 {
-		b.send(b.buf)
+	for _, lane := range b.lanes {
+		if lane.docCt > 0 && !b.stopped {
+			b.send(lane)
+		}
 	}
 	b.mu.Unlock()
 }
 
+// The number of docs in the lanes buffers, mu must be held
+func (b *BulkIndexor) bufferedDocs() int {
+	docs := 0
+	for _, lane := range b.lanes {
+		docs += lane.docCt
+	}
+	return docs
+}
+
 func (b *BulkIndexor) startHttpSendor() {
 
 	// this sends http requests to elasticsearch it uses maxConns to open up that
 	// many goroutines, each of which will synchronously call ElasticSearch
 	// in theory, the whole set will cause a backup all the way to IndexBulk if
-	// we have consumed all maxConns
-	for i := 0; i < b.maxConns; i++ {
-		go func() {
-			for {
-				buf := <-b.sendBuf
-				err := b.BulkSendor(buf)
-
-				// Perhaps a b.FailureStrategy(err)  ??  with different types of strategies
-				//  1.  Retry, then panic
-				//  2.  Retry then return error and let runner decide
-				//  3.  Retry, then log to disk?   retry later?
-				if err != nil {
-					if b.RetryForSeconds > 0 {
-						time.Sleep(time.Second * time.Duration(b.RetryForSeconds))
-						err = b.BulkSendor(buf)
-						if err == nil {
-							continue
-						}
-					}
-					if b.ErrorChannel != nil {
-						log.Println(err)
-						b.ErrorChannel <- &ErrorBuffer{err, buf}
+	// we have consumed all maxConns.   When Ordered each lane has one.
+	for _, lane := range b.lanes {
+		b.sendors.Add(1)
+		go b.pumpLane(lane)
+		for i := 0; i < b.maxConns/len(b.lanes); i++ {
+			b.sendors.Add(1)
+			go func(lane *bulkLane) {
+				defer b.sendors.Done()
+				for buf := range lane.sendBuf {
+					b.sendBuffer(buf)
+				}
+			}(lane)
+		}
+	}
+}
+
+// Move the full buffers of @lane to its sendors, in order, and once shutdown has
+// sent the last of them close its sendBuf.   It waits on the sendors without mu.
+func (b *BulkIndexor) pumpLane(lane *bulkLane) {
+	defer b.sendors.Done()
+	for {
+		b.mu.Lock()
+		for len(lane.full) == 0 && !b.stopped {
+			b.laneCond.Wait()
+		}
+		if len(lane.full) == 0 {
+			b.mu.Unlock()
+			close(lane.sendBuf)
+			return
+		}
+		buf := lane.full[0]
+		lane.full[0] = nil
+		lane.full = lane.full[1:]
+		b.laneCond.Broadcast()
+		b.mu.Unlock()
+		select {
+		case lane.sendBuf <- buf:
+		case <-b.aborted:
+			atomic.AddUint64(&b.failedCt, uint64(len(bulkItems(buf.Bytes()))))
+			b.releaseBytes(buf.Len())
+			putBulkBuffer(buf)
+		}
+	}
+}
+
+// Send a buffer, retrying once after RetryForSeconds if it fails.   If only some of
+// the items failed, only those that may succeed later (rejected or unavailable) are
+// retried.   What still fails goes to the ErrorChannel, failed items one per
+// ErrorBuffer holding their original bulk bytes.
+func (b *BulkIndexor) sendBuffer(buf *bytes.Buffer) {
+	data := buf.Bytes()
+	defer func(n int) {
+		b.releaseBytes(n)
+		putBulkBuffer(buf)
+	}(len(data))
+	if b.Spool != nil && b.Spool.Len() > 0 {
+		// queue behind the older buffers, unless it is full
+		if b.Spool.Put(data) == nil {
+			return
+		}
+	}
+	err := b.sendOnce(data, false)
+
+	// Perhaps a b.FailureStrategy(err)  ??  with different types of strategies
+	//  1.  Retry, then panic
+	//  2.  Retry then return error and let runner decide
+	//  3.  Retry, then log to disk?   retry later?
+	if err != nil && b.RetryForSeconds > 0 {
+		if itemsErr, ok := err.(*BulkItemsError); ok {
+			data = b.failedItems(data, itemsErr, true)
+		}
+		if len(data) == 0 {
+			return
+		}
+		select {
+		case <-time.After(time.Second * time.Duration(b.RetryForSeconds)):
+		case <-b.aborted:
+			b.spoolOrReport(data, err)
+			return
+		}
+		err = b.sendOnce(data, true)
+	}
+	if err == nil {
+		return
+	}
+	if itemsErr, ok := err.(*BulkItemsError); ok {
+		// items that may yet succeed can wait in the spool
+		if retry := b.failedItems(data, itemsErr, b.Spool != nil); len(retry) > 0 {
+			b.spoolOrReport(retry, err)
+		}
+	} else {
+		log.Println(err)
+		b.spoolOrReport(data, err)
+	}
+}
+
+// Keep undelivered bulk @data in the Spool, or if there is none or it is full,
+// report it as failed
+func (b *BulkIndexor) spoolOrReport(data []byte, err error) {
+	if b.Spool != nil {
+		spoolErr := b.Spool.Put(data)
+		if spoolErr == nil {
+			return
+		}
+		log.Println("could not spool bulk buffer: ", spoolErr)
+	}
+	b.reportError(err, data)
+}
+
+// Send the spooled buffers whenever the RetryInterval passes, oldest first, until
+// one fails.   When closing, it tries once more and leaves what fails on disk.
+func (b *BulkIndexor) startSpoolReplay() {
+	if b.Spool == nil {
+		return
+	}
+	interval := b.Spool.RetryInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	b.sendors.Add(1)
+	go func() {
+		defer b.sendors.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			for b.replayOldest() {
+				select {
+				case <-b.aborted:
+					return
+				default:
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-b.closing:
+				for b.replayOldest() {
+					select {
+					case <-b.aborted:
+						return
+					default:
 					}
 				}
+				return
 			}
-		}()
+		}
+	}()
+}
+
+// Send the oldest spooled buffer, true if it is done with and the next can go
+func (b *BulkIndexor) replayOldest() bool {
+	name, data, err := b.Spool.oldest()
+	if err != nil {
+		log.Println("could not read bulk spool: ", err)
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	err = b.sendOnce(data, true)
+	if itemsErr, ok := err.(*BulkItemsError); ok {
+		// it stays first in line with only the items still to send
+		if retry := b.failedItems(data, itemsErr, true); len(retry) > 0 {
+			if err = b.Spool.replace(name, retry); err != nil {
+				log.Println("could not rewrite bulk spool: ", err)
+			}
+			return false
+		}
+	} else if err != nil {
+		return false
+	}
+	if err = b.Spool.remove(name); err != nil {
+		log.Println("could not remove from bulk spool: ", err)
+		return false
+	}
+	return true
+}
+
+// Count the failed docs and send them to the ErrorChannel, unless a Close
+// has given up waiting.   @data is copied as its buffer goes back to the pool.
+func (b *BulkIndexor) reportError(err error, data []byte) {
+	atomic.AddUint64(&b.failedCt, uint64(len(bulkItems(data))))
+	if b.ErrorChannel == nil {
+		return
+	}
+	select {
+	case b.ErrorChannel <- &ErrorBuffer{err, bytes.NewBuffer(append([]byte(nil), data...))}:
+	case <-b.aborted:
 	}
 }
 
-// start a timer for checking back and forcing flush ever BulkDelaySeconds seconds
+// Report the failed items of the bulk @data to the ErrorChannel, except if @retry
+// those that are retryable, whose bytes are returned
+func (b *BulkIndexor) failedItems(data []byte, itemsErr *BulkItemsError, retry bool) []byte {
+	items := bulkItems(data)
+	if len(items) != len(itemsErr.Response.Items) {
+		// can't tell which item is which, so the whole buffer failed
+		b.reportError(itemsErr, data)
+		return nil
+	}
+	var retryBuf bytes.Buffer
+	for i, item := range itemsErr.Response.Items {
+		if !item.Failed() {
+			continue
+		}
+		if retry && item.Retryable() {
+			retryBuf.Write(items[i])
+			continue
+		}
+		if b.OnItemFailure != nil {
+			b.OnItemFailure(item, items[i])
+		}
+		b.reportError(&BulkItemError{item}, items[i])
+	}
+	return retryBuf.Bytes()
+}
+
+// start a timer for checking back and forcing flush every FlushInterval
 // even if we haven't hit max messages/size
 func (b *BulkIndexor) startTimer() {
-	u.Debug("Starting Bulk timer with delay = ", BulkDelaySeconds)
-	ticker := time.NewTicker(time.Second * time.Duration(BulkDelaySeconds))
+	interval := b.Config().FlushInterval
+	u.Debug("Starting Bulk timer with delay = ", interval)
+	ticker := time.NewTicker(interval)
 	go func() {
-		for _ = range ticker.C {
+		defer close(b.timerDone)
+		closing := b.closing
+		for {
+			flushNow := false
+			select {
+			case <-ticker.C:
+			case <-b.flushNow:
+				// callers are waiting for room, so don't wait for the tick
+				flushNow = true
+			case <-b.resetTimer:
+				ticker.Stop()
+				ticker = time.NewTicker(b.Config().FlushInterval)
+				continue
+			case <-closing:
+				// callers still adding may wait for room, which only the partly
+				// filled buffers can make, so keep flushing until they are done
+				closing = nil
+				flushNow = true
+			case <-b.docDone:
+				ticker.Stop()
+				return
+			}
 			b.mu.Lock()
 			// don't send unless last sendor was the time,
 			// otherwise an indication of other thresholds being hit
-			// where time isn't needed
-			if b.buf.Len() > 0 && b.lastSendorByTime {
+			// where time isn't needed, so skip this one tick
+			if !b.lastSendorByTime && !flushNow {
 				b.lastSendorByTime = true
-				b.send(b.buf)
+			} else {
+				for _, lane := range b.lanes {
+					if lane.buf.Len() > 0 {
+						b.send(lane)
+					}
+				}
 			}
 			b.mu.Unlock()
 
@@ -190,25 +658,78 @@ func (b *BulkIndexor) startDocChannel() {
 	// This goroutine accepts incoming byte arrays from the IndexBulk function and
 	// writes to buffer
 	go func() {
-		for docBytes := range b.bulkChannel {
+		defer close(b.docDone)
+		for doc := range b.bulkChannel {
 			b.mu.Lock()
-			b.docCt += 1
-			b.buf.Write(docBytes)
-			if b.buf.Len() >= BulkMaxBuffer || b.docCt >= BulkMaxDocs {
+			lane := b.lanes[doc.lane%len(b.lanes)]
+			if len(doc.key) > 0 {
+				lane = b.lanes[b.laneIndex(doc.key)]
+			}
+			lane.docCt += 1
+			lane.buf.Write(doc.buf.Bytes())
+			putBulkBuffer(doc.buf)
+			if lane.buf.Len() >= b.config.MaxBuffer || lane.docCt >= b.maxDocs() {
 				b.lastSendorByTime = false
-				//log.Printf("Send due to size:  docs=%d  bufsize=%d", b.docCt, b.buf.Len())
-				b.send(b.buf)
+				//log.Printf("Send due to size:  docs=%d  bufsize=%d", lane.docCt, lane.buf.Len())
+				b.send(lane)
+			} else if len(b.bulkChannel) == 0 && b.waitingForBytes() {
+				// a flush asked for by the waiting callers may have come before
+				// these docs reached the buffer
+				b.send(lane)
 			}
 			b.mu.Unlock()
 		}
 	}()
 }
 
-func (b *BulkIndexor) send(buf *bytes.Buffer) {
-	//b2 := *b.buf
-	b.sendBuf <- buf
-	b.buf = new(bytes.Buffer)
-	b.docCt = 0
+// The lane of a doc, by the hash of its index/id, docs without an id take turns
+func (b *BulkIndexor) laneIndex(key string) int {
+	if len(b.lanes) == 1 {
+		return 0
+	}
+	if len(key) == 0 {
+		b.nextLane = (b.nextLane + 1) % len(b.lanes)
+		return b.nextLane
+	}
+	return int(crc32.ChecksumIEEE([]byte(key)) % uint32(len(b.lanes)))
+}
+
+// Queue the lanes buffer for its sendors, mu must be held
+func (b *BulkIndexor) send(lane *bulkLane) {
+	select {
+	case <-b.aborted:
+		atomic.AddUint64(&b.failedCt, uint64(lane.docCt))
+		b.releaseBytes(lane.buf.Len())
+		putBulkBuffer(lane.buf)
+	default:
+		lane.full = append(lane.full, lane.buf)
+		b.laneCond.Broadcast()
+	}
+	lane.buf = getBulkBuffer()
+	lane.docCt = 0
+}
+
+// Split bulk formatted data into its items, each is an action line followed by
+// a source line, except deletes which have no source
+func bulkItems(data []byte) [][]byte {
+	items := make([][]byte, 0)
+	for len(data) > 0 {
+		n := lineLen(data)
+		if !bytes.HasPrefix(data, []byte(`{"delete"`)) && n < len(data) {
+			n += lineLen(data[n:])
+		}
+		items = append(items, data[:n])
+		data = data[n:]
+	}
+	return items
+}
+
+// length of the first line, including the newline
+func lineLen(data []byte) int {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1
+	}
+	return len(data)
 }
 
 // The index bulk API adds or updates a typed JSON document to a specific index, making it searchable.
@@ -216,60 +737,379 @@ func (b *BulkIndexor) send(buf *bytes.Buffer) {
 // http://www.elasticsearch.org/guide/reference/api/bulk.html
 func (b *BulkIndexor) Index(index string, _type string, id string, date *time.Time, data interface{}) error {
 	//{ "index" : { "_index" : "test", "_type" : "type1", "_id" : "1" } }
-	by, err := IndexBulkBytes(index, _type, id, date, data)
-	if err != nil {
+	return b.IndexRouted(index, _type, id, "", "", date, data)
+}
+
+// Index a document with a custom @routing value and/or @parent id (either "" for none),
+// the parent is also used for routing so that child documents live on the parents shard
+func (b *BulkIndexor) IndexRouted(index, _type, id, routing, parent string, date *time.Time, data interface{}) error {
+	meta := BulkMeta{Index: index, Type: _type, Id: id, Routing: routing, Parent: parent}
+	meta.SetTimestamp(date)
+	return b.Action(BulkIndexAction, meta, data)
+}
+
+// Create a document, the item fails if a document with this id already exists
+func (b *BulkIndexor) Create(index string, _type string, id string, date *time.Time, data interface{}) error {
+	meta := BulkMeta{Index: index, Type: _type, Id: id}
+	meta.SetTimestamp(date)
+	return b.Action(BulkCreateAction, meta, data)
+}
+
+// Update a document, @update is a BulkUpdate (partial doc, or script, with optional
+// upsert) or the raw update body
+func (b *BulkIndexor) Update(index string, _type string, id string, update interface{}) error {
+	return b.Action(BulkUpdateAction, BulkMeta{Index: index, Type: _type, Id: id}, update)
+}
+
+// Delete a document
+func (b *BulkIndexor) Delete(index string, _type string, id string) error {
+	return b.Action(BulkDeleteAction, BulkMeta{Index: index, Type: _type, Id: id}, nil)
+}
+
+// Add any bulk action [index, create, update, delete] with its metadata, @data is the
+// document (index, create), the update (see Update), or nil (delete)
+func (b *BulkIndexor) Action(action string, meta BulkMeta, data interface{}) error {
+	buf := getBulkBuffer()
+	if err := writeBulkAction(buf, action, &meta, data); err != nil {
+		putBulkBuffer(buf)
 		u.Error(err)
+		return err
+	}
+	return b.add(bulkKey(meta.Index, meta.Id), buf)
+}
+
+// The key of a doc, for its lane when Ordered
+func bulkKey(index, id string) string {
+	if len(id) == 0 {
+		return ""
+	}
+	return index + "/" + id
+}
+
+// The error of adding a document to a closed BulkIndexor
+var ErrBulkIndexorClosed = errors.New("bulk indexor is closed")
+
+// hand a pooled buffer of bulk bytes to the doc goroutine, unless closed, waiting
+// while its lane has full buffers queued, and while MaxInFlightBytes are in flight
+func (b *BulkIndexor) add(key string, buf *bytes.Buffer) error {
+	b.closeMu.RLock()
+	if b.closed {
+		b.closeMu.RUnlock()
+		putBulkBuffer(buf)
+		return ErrBulkIndexorClosed
+	}
+	b.adding.Add(1)
+	b.closeMu.RUnlock()
+	defer b.adding.Done()
+	lane, ok := b.waitLane(key)
+	if !ok {
+		putBulkBuffer(buf)
+		return ErrBulkIndexorClosed
+	}
+	n := buf.Len()
+	if !b.reserveBytes(n) {
+		putBulkBuffer(buf)
+		return ErrBulkIndexorClosed
+	}
+	select {
+	case b.bulkChannel <- bulkDoc{key, lane, buf}:
+		return nil
+	case <-b.aborted:
+		b.releaseBytes(n)
+		putBulkBuffer(buf)
+		return ErrBulkIndexorClosed
+	}
+}
+
+// Wait until the lane of @key has no full buffers queued, or for docs without a
+// key any lane, false if a Close gave up waiting.   It returns the index of the lane.
+func (b *BulkIndexor) waitLane(key string) (int, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		select {
+		case <-b.aborted:
+			return 0, false
+		default:
+		}
+		tries := 1
+		if len(key) == 0 {
+			tries = len(b.lanes)
+		}
+		for ; tries > 0; tries-- {
+			if i := b.laneIndex(key); len(b.lanes[i].full) == 0 {
+				return i, true
+			}
+		}
+		b.laneCond.Wait()
 	}
-	b.bulkChannel <- by
-	return nil
 }
 
 // This does the actual send of a buffer, which has already been formatted
-// into bytes of ES formatted bulk data
+// into bytes of ES formatted bulk data.   If the request succeeds but some of its
+// items fail, the error is a *BulkItemsError with the response.
 func BulkSend(buf *bytes.Buffer) error {
-	_, err := api.DoCommand("POST", "/_bulk", buf)
+	body, err := api.DoCommand("POST", "/_bulk", buf)
 	if err != nil {
 		log.Println(err)
-		BulkErrorCt += 1
+		atomic.AddUint64(&BulkErrorCt, 1)
+		return err
+	}
+	return bulkResponseError(body)
+}
+
+// A BulkSendor for another cluster than the api's Domain and Port, such as to copy
+// an index into it
+//
+//    indexor.BulkSendor = BulkSendTo("http://backup-host:9200")
+func BulkSendTo(baseUrl string) func(*bytes.Buffer) error {
+	url := strings.TrimRight(baseUrl, "/") + "/_bulk"
+	return func(buf *bytes.Buffer) error {
+		resp, err := http.Post(url, "application/json", buf)
+		if err != nil {
+			atomic.AddUint64(&BulkErrorCt, 1)
+			return err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			atomic.AddUint64(&BulkErrorCt, 1)
+			return err
+		}
+		if resp.StatusCode > 304 {
+			atomic.AddUint64(&BulkErrorCt, 1)
+			return fmt.Errorf("Error [%s] Status [%d]", bytes.TrimSpace(body), resp.StatusCode)
+		}
+		return bulkResponseError(body)
+	}
+}
+
+// the error of a bulk response, nil if all the items succeeded
+func bulkResponseError(body []byte) error {
+	resp, err := parseBulkResponse(body)
+	if err != nil {
+		atomic.AddUint64(&BulkErrorCt, 1)
+		return err
+	}
+	if failed := len(resp.Failed()); failed > 0 {
+		atomic.AddUint64(&BulkErrorCt, uint64(failed))
+		return &BulkItemsError{resp}
+	}
+	return nil
+}
+
+// The response of a bulk request, with a result per item in the order sent
+//    {"took":2,"items":[{"index":{"_index":"test","_type":"type1","_id":"1","_version":1,"ok":true}}]}
+type BulkResponse struct {
+	Took   int              `json:"took"`
+	Errors bool             `json:"errors"`
+	Items  []BulkItemResult `json:"items"`
+}
+
+// older servers don't send errors, it is set from the items
+func parseBulkResponse(body []byte) (BulkResponse, error) {
+	var resp BulkResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return resp, err
+	}
+	resp.Errors = resp.Errors || len(resp.Failed()) > 0
+	return resp, nil
+}
+
+// The items that failed
+func (r *BulkResponse) Failed() []BulkItemResult {
+	failed := make([]BulkItemResult, 0)
+	for _, item := range r.Items {
+		if item.Failed() {
+			failed = append(failed, item)
+		}
+	}
+	return failed
+}
+
+// The result of one item of a bulk request.   Status is only sent by newer servers,
+// older ones send Ok.
+type BulkItemResult struct {
+	Action  string `json:"-"`
+	Index   string `json:"_index"`
+	Type    string `json:"_type"`
+	Id      string `json:"_id"`
+	Version int    `json:"_version"`
+	Ok      bool   `json:"ok"`
+	Status  int    `json:"status"`
+	Error   string `json:"error"`
+}
+
+// The items are keyed by their action {"index": {...}}
+func (r *BulkItemResult) UnmarshalJSON(data []byte) error {
+	var item map[string]json.RawMessage
+	if err := json.Unmarshal(data, &item); err != nil {
 		return err
 	}
+	for action, raw := range item {
+		// plain has the fields but not this method, error is read separately as it
+		// is a string, or an object on newer servers
+		type plain BulkItemResult
+		var res struct {
+			plain
+			Error json.RawMessage `json:"error"`
+		}
+		if err := json.Unmarshal(raw, &res); err != nil {
+			return err
+		}
+		*r = BulkItemResult(res.plain)
+		r.Action = action
+		if len(res.Error) > 0 && string(res.Error) != "null" {
+			if json.Unmarshal(res.Error, &r.Error) != nil {
+				r.Error = string(res.Error)
+			}
+		}
+	}
 	return nil
 }
 
+func (r *BulkItemResult) Failed() bool {
+	return len(r.Error) > 0
+}
+
+// Is the failure one that may succeed if sent again: the node was too busy (429,
+// EsRejectedExecutionException) or the shard was not available
+func (r *BulkItemResult) Retryable() bool {
+	return r.Status == 429 || r.Status == 503 ||
+		strings.Contains(r.Error, "EsRejectedExecutionException") ||
+		strings.Contains(r.Error, "UnavailableShardsException")
+}
+
+// The error of a bulk request where some items failed
+type BulkItemsError struct {
+	Response BulkResponse
+}
+
+func (e *BulkItemsError) Error() string {
+	failed := e.Response.Failed()
+	msg := fmt.Sprintf("%d of %d bulk items failed", len(failed), len(e.Response.Items))
+	if len(failed) > 0 {
+		msg += ": " + failed[0].Error
+	}
+	return msg
+}
+
+// The error of a single failed bulk item, sent on the ErrorChannel with the item's bytes
+type BulkItemError struct {
+	Item BulkItemResult
+}
+
+func (e *BulkItemError) Error() string {
+	return fmt.Sprintf("bulk %s of %s/%s/%s failed: %s", e.Item.Action, e.Item.Index, e.Item.Type, e.Item.Id, e.Item.Error)
+}
+
+// The bulk actions
+const (
+	BulkIndexAction  = "index"
+	BulkCreateAction = "create"
+	BulkUpdateAction = "update"
+	BulkDeleteAction = "delete"
+)
+
+// The metadata of a bulk action, the json of the action line
+//    { "index" : { "_index" : "test", "_type" : "type1", "_id" : "1" } }
+type BulkMeta struct {
+	Index           string `json:"_index"`
+	Type            string `json:"_type"`
+	Id              string `json:"_id,omitempty"`
+	Routing         string `json:"_routing,omitempty"`
+	Parent          string `json:"_parent,omitempty"`
+	Timestamp       string `json:"_timestamp,omitempty"`
+	TTL             string `json:"_ttl,omitempty"`
+	Version         int64  `json:"_version,omitempty"`
+	VersionType     string `json:"_version_type,omitempty"`
+	RetryOnConflict int    `json:"_retry_on_conflict,omitempty"` // update only
+}
+
+// Set the timestamp from a time (nil for none), as elasticsearch epoch millis
+func (m *BulkMeta) SetTimestamp(date *time.Time) {
+	if date != nil {
+		m.Timestamp = strconv.FormatInt(date.UnixNano()/1e6, 10)
+	}
+}
+
+// The body of a bulk update, either a partial Doc or a Script (with optional Lang and
+// Params), and optionally an Upsert document to index if the document does not exist
+//
+//   BulkUpdate{Doc: map[string]interface{}{"name": "smurfs"}, DocAsUpsert: true}
+//   BulkUpdate{Script: "ctx._source.ct += inc", Params: map[string]interface{}{"inc": 1}, Upsert: doc}
+type BulkUpdate struct {
+	Doc         interface{}            `json:"doc,omitempty"`
+	DocAsUpsert bool                   `json:"doc_as_upsert,omitempty"`
+	Script      string                 `json:"script,omitempty"`
+	Lang        string                 `json:"lang,omitempty"`
+	Params      map[string]interface{} `json:"params,omitempty"`
+	Upsert      interface{}            `json:"upsert,omitempty"`
+}
+
 // Given a set of arguments for index, type, id, data create a set of bytes that is formatted for bulkd index
 // http://www.elasticsearch.org/guide/reference/api/bulk.html
 func IndexBulkBytes(index string, _type string, id string, date *time.Time, data interface{}) ([]byte, error) {
-	//{ "index" : { "_index" : "test", "_type" : "type1", "_id" : "1" } }
-	buf := bytes.Buffer{}
-	buf.WriteString(`{"index":{"_index":"`)
-	buf.WriteString(index)
-	buf.WriteString(`","_type":"`)
-	buf.WriteString(_type)
-	buf.WriteString(`","_id":"`)
-	buf.WriteString(id)
-	if date != nil {
-		buf.WriteString(`","_timestamp":"`)
-		buf.WriteString(strconv.FormatInt(date.UnixNano()/1e6, 10))
+	meta := BulkMeta{Index: index, Type: _type, Id: id}
+	meta.SetTimestamp(date)
+	return BulkActionBytes(BulkIndexAction, &meta, data)
+}
+
+// Create the bulk formatted bytes of any action, the json encoded action line and
+// then, except for deletes, the document or update body line
+// http://www.elasticsearch.org/guide/reference/api/bulk.html
+func BulkActionBytes(action string, meta *BulkMeta, data interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := writeBulkAction(buf, action, meta, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Append the bulk formatted action to @buf, json is encoded straight into it.   On
+// error nothing is appended.
+func writeBulkAction(buf *bytes.Buffer, action string, meta *BulkMeta, data interface{}) error {
+	switch action {
+	case BulkIndexAction, BulkCreateAction, BulkUpdateAction:
+		if data == nil {
+			return fmt.Errorf("bulk %s of %s/%s/%s has no data", action, meta.Index, meta.Type, meta.Id)
+		}
+	case BulkDeleteAction:
+	default:
+		return fmt.Errorf("unknown bulk action %q", action)
+	}
+	start := buf.Len()
+	buf.WriteString(`{"`)
+	buf.WriteString(action)
+	buf.WriteString(`":`)
+	enc := json.NewEncoder(buf)
+	if err := enc.Encode(meta); err != nil {
+		buf.Truncate(start)
+		return err
+	}
+	// in place of the newline the encoder ends with
+	buf.Truncate(buf.Len() - 1)
+	buf.WriteString("}\n")
+	if action == BulkDeleteAction {
+		return nil
 	}
-	buf.WriteString(`"}}`)
-	buf.WriteByte('\n')
 	switch v := data.(type) {
 	case *bytes.Buffer:
-		io.Copy(&buf, v)
+		io.Copy(buf, v)
 	case []byte:
 		buf.Write(v)
 	case string:
 		buf.WriteString(v)
 	default:
-		body, jsonErr := json.Marshal(data)
-		if jsonErr != nil {
+		if err := enc.Encode(data); err != nil {
 			log.Println("Json data error ", data)
-			return nil, jsonErr
+			buf.Truncate(start)
+			return err
 		}
-		buf.Write(body)
+		return nil
 	}
 	buf.WriteByte('\n')
-	return buf.Bytes(), nil
+	return nil
 }
 
 // The index bulk API adds or updates a typed JSON document to a specific index, making it searchable.
@@ -280,10 +1120,12 @@ func IndexBulk(index string, _type string, id string, date *time.Time, data inte
 	if bulkIndexor == nil {
 		panic("Must have Global Bulk Indexor to use this Func")
 	}
-	by, err := IndexBulkBytes(index, _type, id, date, data)
-	if err != nil {
+	meta := BulkMeta{Index: index, Type: _type, Id: id}
+	meta.SetTimestamp(date)
+	buf := getBulkBuffer()
+	if err := writeBulkAction(buf, BulkIndexAction, &meta, data); err != nil {
+		putBulkBuffer(buf)
 		return err
 	}
-	bulkIndexor.bulkChannel <- by
-	return nil
+	return bulkIndexor.add(bulkKey(index, id), buf)
 }
@@ -0,0 +1,163 @@
+package core
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Start or stop adapting to @config, with the doc and request limits reset
+func (b *BulkIndexor) setAdaptive(config *BulkConfig) {
+	b.flowMu.Lock()
+	defer b.flowMu.Unlock()
+	b.conns = b.maxConns
+	if config.Adaptive == nil {
+		b.adaptive = nil
+		atomic.StoreInt64(&b.adaptDocs, 0)
+	} else {
+		adaptive := *config.Adaptive
+		b.adaptive = &adaptive
+		atomic.StoreInt64(&b.adaptDocs, int64(clamp(config.MaxDocs, adaptive.MinDocs, adaptive.MaxDocs)))
+	}
+	b.flowCond.Broadcast()
+}
+
+// Lower the adapted docs per batch to @docs, within the adaptive bounds
+func (b *BulkIndexor) capAdaptDocs(docs int) {
+	b.flowMu.Lock()
+	defer b.flowMu.Unlock()
+	if a := b.adaptive; a != nil && int(atomic.LoadInt64(&b.adaptDocs)) > docs {
+		atomic.StoreInt64(&b.adaptDocs, int64(clamp(docs, a.MinDocs, a.MaxDocs)))
+	}
+}
+
+// The max docs per batch, adapted or configured, b.mu must be held
+func (b *BulkIndexor) maxDocs() int {
+	if docs := atomic.LoadInt64(&b.adaptDocs); docs > 0 {
+		return int(docs)
+	}
+	return b.config.MaxDocs
+}
+
+// Wait for a request to be allowed in flight, false if a Close gave up waiting
+func (b *BulkIndexor) acquireConn() bool {
+	b.flowMu.Lock()
+	defer b.flowMu.Unlock()
+	for b.inFlight >= b.conns {
+		select {
+		case <-b.aborted:
+			return false
+		default:
+		}
+		b.flowCond.Wait()
+	}
+	b.inFlight++
+	return true
+}
+
+func (b *BulkIndexor) releaseConn() {
+	b.flowMu.Lock()
+	b.inFlight--
+	b.flowMu.Unlock()
+	b.flowCond.Signal()
+}
+
+// Adapt the batch size and requests in flight to how a request went
+func (b *BulkIndexor) adapt(err error, took time.Duration) {
+	b.flowMu.Lock()
+	defer b.flowMu.Unlock()
+	a := b.adaptive
+	if a == nil {
+		return
+	}
+	docs := int(atomic.LoadInt64(&b.adaptDocs))
+	switch {
+	case bulkRejected(err):
+		docs = docs / 2
+		b.conns = clamp(b.conns/2, a.MinConns, b.maxConns)
+	case took > a.TargetLatency:
+		docs = docs - docs/4
+	case err == nil:
+		docs = docs + docs/10 + 1
+		if b.conns < b.maxConns {
+			b.conns++
+			b.flowCond.Signal()
+		}
+	}
+	atomic.StoreInt64(&b.adaptDocs, int64(clamp(docs, a.MinDocs, a.MaxDocs)))
+}
+
+// Did the cluster reject the request, or some of its items, for being too busy
+func bulkRejected(err error) bool {
+	if err == nil {
+		return false
+	}
+	if itemsErr, ok := err.(*BulkItemsError); ok {
+		for _, item := range itemsErr.Response.Items {
+			if item.Status == 429 || strings.Contains(item.Error, "EsRejectedExecutionException") {
+				return true
+			}
+		}
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "EsRejectedExecutionException") || strings.Contains(msg, "Status [429]")
+}
+
+func clamp(n, min, max int) int {
+	if n < min {
+		n = min
+	}
+	if n > max {
+		n = max
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// Wait for room for @n more bytes in flight, false if a Close gave up waiting.   A
+// doc larger than the limit is let through once nothing else is in flight.
+func (b *BulkIndexor) reserveBytes(n int) bool {
+	b.flowMu.Lock()
+	defer b.flowMu.Unlock()
+	for b.maxInFlight > 0 && b.inFlightBytes > 0 && b.inFlightBytes+int64(n) > b.maxInFlight {
+		select {
+		case <-b.aborted:
+			return false
+		default:
+		}
+		// the bytes may be sitting in partly filled buffers
+		select {
+		case b.flushNow <- true:
+		default:
+		}
+		b.bytesWaiters++
+		b.bytesCond.Wait()
+		b.bytesWaiters--
+	}
+	b.inFlightBytes += int64(n)
+	return true
+}
+
+// Are callers waiting for room in flight, so the buffers should not wait to fill
+func (b *BulkIndexor) waitingForBytes() bool {
+	b.flowMu.Lock()
+	defer b.flowMu.Unlock()
+	return b.bytesWaiters > 0
+}
+
+func (b *BulkIndexor) releaseBytes(n int) {
+	b.flowMu.Lock()
+	b.inFlightBytes -= int64(n)
+	b.flowMu.Unlock()
+	b.bytesCond.Broadcast()
+}
+
+func (b *BulkIndexor) setMaxInFlight(bytes int) {
+	b.flowMu.Lock()
+	b.maxInFlight = int64(bytes)
+	b.flowMu.Unlock()
+	b.bytesCond.Broadcast()
+}
@@ -0,0 +1,186 @@
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+// The thresholds at which a BulkIndexor sends its buffer, whichever is hit first.
+// Each indexor has its own, so a low latency indexor can run alongside one doing
+// large batches:
+//
+//   fast, err := NewBulkIndexorConfig(2, WithMaxDocs(10), WithFlushInterval(100*time.Millisecond))
+//   backfill, err := NewBulkIndexorConfig(8, WithMaxDocs(5000), WithMaxBuffer(10<<20))
+type BulkConfig struct {
+	// Max buffer size in bytes before flushing to elasticsearch
+	MaxBuffer int
+	// Max number of docs to hold in buffer before forcing flush
+	MaxDocs int
+	// Max delay before forcing a flush, may be less than a second
+	FlushInterval time.Duration
+	// Max bytes added but not yet sent, counting the buffers being filled, waiting
+	// and in flight, Index blocks while over it, 0 for no limit
+	MaxInFlightBytes int
+	// Optional adaptive sizing, which then changes the max docs within its bounds
+	Adaptive *BulkAdaptive
+}
+
+// The config of new indexors, from BulkMaxBuffer, BulkMaxDocs and BulkDelaySeconds
+func DefaultBulkConfig() BulkConfig {
+	return BulkConfig{
+		MaxBuffer:     BulkMaxBuffer,
+		MaxDocs:       BulkMaxDocs,
+		FlushInterval: time.Second * time.Duration(BulkDelaySeconds),
+	}
+}
+
+func (c *BulkConfig) Validate() error {
+	if c.MaxBuffer <= 0 {
+		return fmt.Errorf("bulk MaxBuffer must be positive, was %d", c.MaxBuffer)
+	}
+	if c.MaxDocs <= 0 {
+		return fmt.Errorf("bulk MaxDocs must be positive, was %d", c.MaxDocs)
+	}
+	if c.FlushInterval < time.Millisecond {
+		return fmt.Errorf("bulk FlushInterval must be at least 1ms, was %v", c.FlushInterval)
+	}
+	if c.MaxInFlightBytes != 0 && c.MaxInFlightBytes < c.MaxBuffer {
+		return fmt.Errorf("bulk MaxInFlightBytes must be 0 or at least MaxBuffer %d, was %d", c.MaxBuffer, c.MaxInFlightBytes)
+	}
+	if c.Adaptive != nil {
+		return c.Adaptive.validate()
+	}
+	return nil
+}
+
+// An option changing a BulkConfig
+type BulkOption func(*BulkConfig)
+
+func WithMaxBuffer(bytes int) BulkOption {
+	return func(c *BulkConfig) {
+		c.MaxBuffer = bytes
+	}
+}
+
+func WithMaxDocs(docs int) BulkOption {
+	return func(c *BulkConfig) {
+		c.MaxDocs = docs
+	}
+}
+
+func WithFlushInterval(interval time.Duration) BulkOption {
+	return func(c *BulkConfig) {
+		c.FlushInterval = interval
+	}
+}
+
+func WithMaxInFlightBytes(bytes int) BulkOption {
+	return func(c *BulkConfig) {
+		c.MaxInFlightBytes = bytes
+	}
+}
+
+// A bulk indexor with the default config changed by @opts
+//    @maxConns is the max number of in flight http requests
+func NewBulkIndexorConfig(maxConns int, opts ...BulkOption) (*BulkIndexor, error) {
+	b := NewBulkIndexor(maxConns)
+	for _, opt := range opts {
+		opt(&b.config)
+	}
+	if err := b.config.Validate(); err != nil {
+		return nil, err
+	}
+	b.setAdaptive(&b.config)
+	b.setMaxInFlight(b.config.MaxInFlightBytes)
+	return b, nil
+}
+
+// The current config
+func (b *BulkIndexor) Config() BulkConfig {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.config
+}
+
+// Change the config of a running indexor, an invalid config is not applied.   The
+// new thresholds apply to the buffer being filled, a new FlushInterval restarts
+// the timer.   A new Adaptive starts adapting over from MaxDocs, while a lower
+// MaxDocs only caps the size adapted so far.
+func (b *BulkIndexor) SetConfig(opts ...BulkOption) error {
+	b.mu.Lock()
+	config := b.config
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if err := config.Validate(); err != nil {
+		b.mu.Unlock()
+		return err
+	}
+	intervalChanged := config.FlushInterval != b.config.FlushInterval
+	if !sameAdaptive(config.Adaptive, b.config.Adaptive) {
+		b.setAdaptive(&config)
+	} else if config.MaxDocs < b.config.MaxDocs {
+		b.capAdaptDocs(config.MaxDocs)
+	}
+	b.config = config
+	b.setMaxInFlight(config.MaxInFlightBytes)
+	for _, lane := range b.lanes {
+		full := lane.docCt > 0 && (lane.buf.Len() >= config.MaxBuffer || lane.docCt >= b.maxDocs())
+		if full && !b.stopped {
+			b.lastSendorByTime = false
+			b.send(lane)
+		}
+	}
+	b.mu.Unlock()
+	if intervalChanged {
+		// the timer may already be told to reset, which reads the latest
+		select {
+		case b.resetTimer <- true:
+		default:
+		}
+	}
+	return nil
+}
+
+// Adaptive sizing for a BulkIndexor, see WithAdaptive.   Batches grow while requests
+// are faster than TargetLatency, and shrink when slower.   When the cluster rejects
+// requests (429, EsRejectedExecutionException) both the batch size and the number of
+// requests in flight are halved, then grow back one at a time.   As fewer requests
+// are in flight, full buffers back up and Index blocks, slowing the callers down.
+type BulkAdaptive struct {
+	// Bounds of the docs per batch, which starts at the configs MaxDocs
+	MinDocs int
+	MaxDocs int
+	// Least requests in flight, at least 1, the most is the indexors maxConns
+	MinConns int
+	// Latency of a bulk request to aim for
+	TargetLatency time.Duration
+}
+
+// Are @a and @b both off, or the same settings
+func sameAdaptive(a, b *BulkAdaptive) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func (a *BulkAdaptive) validate() error {
+	if a.MinDocs <= 0 || a.MaxDocs < a.MinDocs {
+		return fmt.Errorf("bulk adaptive docs must be 0 < MinDocs <= MaxDocs, was %d, %d", a.MinDocs, a.MaxDocs)
+	}
+	if a.MinConns < 0 {
+		return fmt.Errorf("bulk adaptive MinConns can't be negative, was %d", a.MinConns)
+	}
+	if a.TargetLatency <= 0 {
+		return fmt.Errorf("bulk adaptive TargetLatency must be positive, was %v", a.TargetLatency)
+	}
+	return nil
+}
+
+// Adapt the batch size and requests in flight, see BulkAdaptive, nil to turn it off
+func WithAdaptive(adaptive *BulkAdaptive) BulkOption {
+	return func(c *BulkConfig) {
+		c.Adaptive = adaptive
+	}
+}
@@ -0,0 +1,93 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"github.com/mattbaird/elastigo/api"
+)
+
+// A BulkRequest collects actions and sends them in a single _bulk call, waiting for
+// the response, unlike the BulkIndexor which streams in the background.   The first
+// error encoding an action is returned by Do.
+//
+//   resp, err := NewBulkRequest().
+//     Index(BulkMeta{Index: "users", Type: "user", Id: "1"}, user).
+//     Update(BulkMeta{Index: "users", Type: "user", Id: "2", RetryOnConflict: 3}, BulkUpdate{Doc: changes}).
+//     Delete(BulkMeta{Index: "users", Type: "user", Id: "3", Version: 7}).
+//     Refresh(true).
+//     Do()
+//   if err == nil && resp.Errors {
+//     for _, item := range resp.Failed() { ... }
+//   }
+type BulkRequest struct {
+	buf     bytes.Buffer
+	actions int
+	refresh bool
+	err     error
+}
+
+func NewBulkRequest() *BulkRequest {
+	return &BulkRequest{}
+}
+
+// Index a document
+func (r *BulkRequest) Index(meta BulkMeta, doc interface{}) *BulkRequest {
+	return r.Action(BulkIndexAction, meta, doc)
+}
+
+// Create a document, the item fails if it already exists
+func (r *BulkRequest) Create(meta BulkMeta, doc interface{}) *BulkRequest {
+	return r.Action(BulkCreateAction, meta, doc)
+}
+
+// Update a document, @update is a BulkUpdate or the raw update body
+func (r *BulkRequest) Update(meta BulkMeta, update interface{}) *BulkRequest {
+	return r.Action(BulkUpdateAction, meta, update)
+}
+
+func (r *BulkRequest) Delete(meta BulkMeta) *BulkRequest {
+	return r.Action(BulkDeleteAction, meta, nil)
+}
+
+// Add any bulk action, see BulkActionBytes
+func (r *BulkRequest) Action(action string, meta BulkMeta, data interface{}) *BulkRequest {
+	if r.err != nil {
+		return r
+	}
+	if r.err = writeBulkAction(&r.buf, action, &meta, data); r.err == nil {
+		r.actions++
+	}
+	return r
+}
+
+// Refresh the shards touched once done, so the changes are searchable on return
+func (r *BulkRequest) Refresh(refresh bool) *BulkRequest {
+	r.refresh = refresh
+	return r
+}
+
+// Number of actions added
+func (r *BulkRequest) Len() int {
+	return r.actions
+}
+
+// Send the actions, the error is of the request, check the response Errors (or
+// Failed) for the items that failed
+func (r *BulkRequest) Do() (BulkResponse, error) {
+	var resp BulkResponse
+	if r.err != nil {
+		return resp, r.err
+	}
+	if r.actions == 0 {
+		return resp, errors.New("bulk request has no actions")
+	}
+	url := "/_bulk"
+	if r.refresh {
+		url += "?refresh=true"
+	}
+	body, err := api.DoCommand("POST", url, bytes.NewReader(r.buf.Bytes()))
+	if err != nil {
+		return resp, err
+	}
+	return parseBulkResponse(body)
+}
@@ -0,0 +1,43 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestBulkRequest(t *testing.T) {
+	var url, body string
+	defer fakeServer(func(w http.ResponseWriter, r *http.Request) {
+		by, _ := ioutil.ReadAll(r.Body)
+		url, body = r.URL.RequestURI(), string(by)
+		fmt.Fprint(w, `{"took":7,"items":[
+			{"index":{"_index":"users","_type":"user","_id":"1","_version":1,"ok":true}},
+			{"update":{"_index":"users","_type":"user","_id":"2","_version":4,"ok":true}},
+			{"delete":{"_index":"users","_type":"user","_id":"3","error":"VersionConflictEngineException[[users][0] [user][3]: version conflict, current [8], provided [7]]"}}]}`)
+	})()
+
+	req := NewBulkRequest().
+		Index(BulkMeta{Index: "users", Type: "user", Id: "1", Routing: "r1", TTL: "1d"}, map[string]interface{}{"name": "bob"}).
+		Update(BulkMeta{Index: "users", Type: "user", Id: "2", Parent: "p1", RetryOnConflict: 3}, BulkUpdate{Doc: map[string]int{"age": 2}}).
+		Delete(BulkMeta{Index: "users", Type: "user", Id: "3", Version: 7, VersionType: "external"}).
+		Refresh(true)
+	Assert(req.Len() == 3, t, "Should have 3 actions %d", req.Len())
+	resp, err := req.Do()
+	Assert(err == nil, t, "Should not have error %v", err)
+	Assert(url == "/_bulk?refresh=true", t, "Should refresh %s", url)
+	Assert(body == `{"index":{"_index":"users","_type":"user","_id":"1","_routing":"r1","_ttl":"1d"}}`+"\n"+`{"name":"bob"}`+"\n"+
+		`{"update":{"_index":"users","_type":"user","_id":"2","_parent":"p1","_retry_on_conflict":3}}`+"\n"+`{"doc":{"age":2}}`+"\n"+
+		`{"delete":{"_index":"users","_type":"user","_id":"3","_version":7,"_version_type":"external"}}`+"\n", t, "Wrong body %s", body)
+
+	Assert(resp.Took == 7 && resp.Errors && len(resp.Items) == 3, t, "Wrong response %v", resp)
+	Assert(resp.Items[1].Action == "update" && resp.Items[1].Version == 4, t, "Wrong item %v", resp.Items[1])
+	failed := resp.Failed()
+	Assert(len(failed) == 1 && failed[0].Id == "3" && !failed[0].Retryable(), t, "Wrong failures %v", failed)
+
+	_, err = NewBulkRequest().Index(BulkMeta{Index: "users", Type: "user"}, nil).Do()
+	Assert(err != nil, t, "Should have the encoding error")
+	_, err = NewBulkRequest().Do()
+	Assert(err != nil, t, "Should not send an empty request")
+}
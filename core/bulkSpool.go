@@ -0,0 +1,175 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The error of putting a buffer in a spool that is over its size limit
+var ErrBulkSpoolFull = errors.New("bulk spool is full")
+
+// A BulkSpool keeps bulk buffers that could not be delivered in a local directory,
+// one file each, so a BulkIndexor can send them once the cluster is back, in the
+// order they were spooled.   Files left by a previous process are picked up, so
+// nothing is lost over a restart.
+//
+//   spool, err := NewBulkSpool("/var/spool/myapp-bulk", 1<<30)
+//   indexor := NewBulkIndexorErrors(10, 60)
+//   indexor.Spool = spool
+//   indexor.Run(done)
+//
+// While a spool is not empty, the indexor spools new buffers too, so they are not
+// sent ahead of older ones.   Once it is full, buffers go to the ErrorChannel.
+type BulkSpool struct {
+	// How often to try sending the spooled buffers while it fails, default 5s
+	RetryInterval time.Duration
+
+	dir      string
+	maxBytes int64
+	mu       sync.Mutex
+	files    []spoolFile
+	size     int64
+	next     uint64
+}
+
+type spoolFile struct {
+	name string
+	size int64
+}
+
+const spoolExt = ".bulk"
+
+// Open the spool in @dir, creating it if needed, holding at most @maxBytes (0 for
+// no limit)
+func NewBulkSpool(dir string, maxBytes int64) (*BulkSpool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &BulkSpool{RetryInterval: 5 * time.Second, dir: dir, maxBytes: maxBytes}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range entries {
+		name := fi.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			// died while writing it, so it was never spooled
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolExt), 10, 64)
+		if err != nil || !strings.HasSuffix(name, spoolExt) {
+			continue
+		}
+		s.files = append(s.files, spoolFile{name, fi.Size()})
+		s.size += fi.Size()
+		if seq >= s.next {
+			s.next = seq + 1
+		}
+	}
+	sort.Slice(s.files, func(i, j int) bool {
+		return s.files[i].name < s.files[j].name
+	})
+	return s, nil
+}
+
+// Number of spooled buffers
+func (s *BulkSpool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.files)
+}
+
+// Total size in bytes of the spooled buffers
+func (s *BulkSpool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Add a buffer of bulk formatted data after the others
+func (s *BulkSpool) Put(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxBytes > 0 && s.size+int64(len(data)) > s.maxBytes {
+		return ErrBulkSpoolFull
+	}
+	name := fmt.Sprintf("%020d%s", s.next, spoolExt)
+	if err := s.write(name, data); err != nil {
+		return err
+	}
+	s.next++
+	s.files = append(s.files, spoolFile{name, int64(len(data))})
+	s.size += int64(len(data))
+	return nil
+}
+
+// The oldest buffer, "" if empty
+func (s *BulkSpool) oldest() (string, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.files) == 0 {
+		return "", nil, nil
+	}
+	name := s.files[0].name
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+	return name, data, err
+}
+
+// Remove the oldest buffer @name, once sent
+func (s *BulkSpool) remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.files) == 0 || s.files[0].name != name {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.size -= s.files[0].size
+	s.files = s.files[1:]
+	return nil
+}
+
+// Replace the oldest buffer @name with what is left of it to send
+func (s *BulkSpool) replace(name string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.files) == 0 || s.files[0].name != name {
+		return nil
+	}
+	if err := s.write(name, data); err != nil {
+		return err
+	}
+	s.size += int64(len(data)) - s.files[0].size
+	s.files[0].size = int64(len(data))
+	return nil
+}
+
+// write a file so it is either all there or not at all
+func (s *BulkSpool) write(name string, data []byte) error {
+	path := filepath.Join(s.dir, name)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
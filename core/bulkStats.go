@@ -0,0 +1,122 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// The running totals of a BulkIndexor, see Stats.   Docs, Bytes and Batches count
+// every request made, so retries are counted again.
+type BulkStats struct {
+	Docs    uint64
+	Bytes   uint64
+	Batches uint64
+	// Requests that were sent again, by a retry or from the spool
+	Retries uint64
+	// Requests that returned an error, including those where only some items failed
+	SendErrors uint64
+	// Docs that failed for good, or were dropped by a Close that timed out
+	Errors uint64
+
+	// Time taken by the requests
+	TotalTime time.Duration
+	LastTime  time.Duration
+	MaxTime   time.Duration
+
+	// Docs waiting to be put in a buffer, or in the buffer being filled
+	QueuedDocs int
+	// Full buffers waiting for a sendor
+	QueuedBatches int
+	// Buffers waiting in the Spool
+	Spooled int
+	// Bytes added but not yet sent, see MaxInFlightBytes
+	InFlightBytes int64
+
+	// The current max docs per batch, and requests allowed in flight, which change
+	// when adaptive
+	BatchDocs int
+	Conns     int
+}
+
+func (s BulkStats) String() string {
+	return fmt.Sprintf("<Bulk docs=%d bytes=%d batches=%d retries=%d senderrors=%d errors=%d time=%v queued=%d/%d spooled=%d batchdocs=%d conns=%d />",
+		s.Docs, s.Bytes, s.Batches, s.Retries, s.SendErrors, s.Errors, s.TotalTime, s.QueuedDocs, s.QueuedBatches, s.Spooled, s.BatchDocs, s.Conns)
+}
+
+// The outcome of one bulk request, for the AfterSend hook
+type BulkSendResult struct {
+	Docs  int
+	Bytes int
+	// Was this a retry, or sent from the spool
+	Retry bool
+	// The error of the request, a *BulkItemsError if only some items failed
+	Err error
+}
+
+// A snapshot of the totals so far, and of the queues now
+func (b *BulkIndexor) Stats() BulkStats {
+	b.statsMu.Lock()
+	stats := b.stats
+	b.statsMu.Unlock()
+	stats.Errors = atomic.LoadUint64(&b.failedCt)
+	b.mu.Lock()
+	stats.QueuedDocs = b.bufferedDocs() + len(b.bulkChannel)
+	stats.BatchDocs = b.maxDocs()
+	for _, lane := range b.lanes {
+		stats.QueuedBatches += len(lane.full) + len(lane.sendBuf)
+	}
+	b.mu.Unlock()
+	b.flowMu.Lock()
+	stats.Conns = b.conns
+	stats.InFlightBytes = b.inFlightBytes
+	b.flowMu.Unlock()
+	if b.Spool != nil {
+		stats.Spooled = b.Spool.Len()
+	}
+	return stats
+}
+
+// Make one bulk request of @data through the BulkSendor, with the hooks and stats
+func (b *BulkIndexor) sendOnce(data []byte, retry bool) error {
+	if b.BeforeSend != nil {
+		b.BeforeSend(data)
+	}
+	if !b.acquireConn() {
+		return ErrBulkIndexorClosed
+	}
+	buf := bytes.NewBuffer(data)
+	if b.copySend {
+		// the data goes back to the pool, but the sendor may hold on to its buffer
+		buf = bytes.NewBuffer(append([]byte(nil), data...))
+	}
+	start := time.Now()
+	err := b.BulkSendor(buf)
+	took := time.Since(start)
+	b.releaseConn()
+	b.adapt(err, took)
+	result := BulkSendResult{Docs: len(bulkItems(data)), Bytes: len(data), Retry: retry, Err: err}
+
+	b.statsMu.Lock()
+	b.stats.Docs += uint64(result.Docs)
+	b.stats.Bytes += uint64(result.Bytes)
+	b.stats.Batches++
+	if retry {
+		b.stats.Retries++
+	}
+	if err != nil {
+		b.stats.SendErrors++
+	}
+	b.stats.TotalTime += took
+	b.stats.LastTime = took
+	if took > b.stats.MaxTime {
+		b.stats.MaxTime = took
+	}
+	b.statsMu.Unlock()
+
+	if b.AfterSend != nil {
+		b.AfterSend(result, took)
+	}
+	return err
+}
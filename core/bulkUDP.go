@@ -1 +1,97 @@
 package core
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mattbaird/elastigo/api"
+	"net"
+	"sync/atomic"
+)
+
+var (
+	// Default port of the elasticsearch bulk udp endpoint
+	BulkUDPPort = "9700"
+	// Default max size in bytes of a bulk udp datagram, the max udp payload over ipv4
+	BulkUDPMaxDatagram = 65507
+)
+
+// A BulkUDP sends bulk formatted data to the elasticsearch bulk udp endpoint, which
+// avoids the tcp and http overhead of BulkSend.  It is fire and forget, there is no
+// response so no way of knowing if a document was indexed.   Its Send is a BulkSendor
+// so it plugs into a BulkIndexor:
+//
+//   udp, err := NewBulkUDP("")
+//   indexor := NewBulkIndexor(2)
+//   indexor.BulkSendor = udp.Send
+//   indexor.Run(done)
+//
+// The endpoint must be enabled on the server (bulk.udp.enabled: true)
+// http://www.elasticsearch.org/guide/reference/api/bulk-udp.html
+type BulkUDP struct {
+	// Max size of a datagram, buffers are split between documents to fit
+	MaxDatagram int
+	conn        net.Conn
+}
+
+// Create a BulkUDP sending to @addr (host:port), "" for api.Domain and BulkUDPPort
+func NewBulkUDP(addr string) (*BulkUDP, error) {
+	if len(addr) == 0 {
+		addr = net.JoinHostPort(api.Domain, BulkUDPPort)
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &BulkUDP{MaxDatagram: BulkUDPMaxDatagram, conn: conn}, nil
+}
+
+// Send a buffer of bulk formatted data (such as from IndexBulkBytes), in as few
+// datagrams as fit.   A document larger than MaxDatagram can not be sent, it is
+// skipped and an error returned after the rest are sent.
+func (b *BulkUDP) Send(buf *bytes.Buffer) error {
+	var tooLarge int
+	data := buf.Bytes()
+	start, end := 0, 0
+	for _, item := range bulkItems(data) {
+		if len(item) > b.MaxDatagram {
+			// flush what we have, then skip over this one
+			if err := b.write(data[start:end]); err != nil {
+				return err
+			}
+			tooLarge++
+			end += len(item)
+			start = end
+			continue
+		}
+		if end-start+len(item) > b.MaxDatagram {
+			if err := b.write(data[start:end]); err != nil {
+				return err
+			}
+			start = end
+		}
+		end += len(item)
+	}
+	if err := b.write(data[start:end]); err != nil {
+		return err
+	}
+	if tooLarge > 0 {
+		atomic.AddUint64(&BulkErrorCt, uint64(tooLarge))
+		return fmt.Errorf("%d docs larger than the max datagram size %d were not sent", tooLarge, b.MaxDatagram)
+	}
+	return nil
+}
+
+func (b *BulkUDP) write(datagram []byte) error {
+	if len(datagram) == 0 {
+		return nil
+	}
+	_, err := b.conn.Write(datagram)
+	if err != nil {
+		atomic.AddUint64(&BulkErrorCt, 1)
+	}
+	return err
+}
+
+func (b *BulkUDP) Close() error {
+	return b.conn.Close()
+}
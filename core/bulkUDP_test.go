@@ -0,0 +1,90 @@
+package core
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a loopback udp listener standing in for the elasticsearch bulk udp endpoint
+func udpListener(t *testing.T) (net.PacketConn, chan string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	datagrams := make(chan string, 100)
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				close(datagrams)
+				return
+			}
+			datagrams <- string(buf[:n])
+		}
+	}()
+	return conn, datagrams
+}
+
+func TestBulkUDPSplit(t *testing.T) {
+	conn, datagrams := udpListener(t)
+	defer conn.Close()
+	udp, err := NewBulkUDP(conn.LocalAddr().String())
+	Assert(err == nil, t, "Should not have error %v", err)
+	defer udp.Close()
+
+	buf := new(bytes.Buffer)
+	for _, id := range []string{"1", "2", "3"} {
+		by, _ := IndexBulkBytes("users", "user", id, nil, map[string]interface{}{"name": "smurfs"})
+		buf.Write(by)
+	}
+	docLen := buf.Len() / 3
+	// room for 2 docs per datagram
+	udp.MaxDatagram = docLen*2 + 1
+	err = udp.Send(buf)
+	Assert(err == nil, t, "Should not have error %v", err)
+
+	d1, d2 := <-datagrams, <-datagrams
+	Assert(strings.Count(d1, "\n") == 4 && strings.HasPrefix(d1, `{"index"`), t, "Should have 2 docs %v", d1)
+	Assert(strings.Count(d2, "\n") == 2 && strings.Contains(d2, `"_id":"3"`), t, "Should have 1 doc %v", d2)
+
+	// a doc that can never fit is skipped
+	buf.Reset()
+	by, _ := IndexBulkBytes("users", "user", "4", nil, map[string]interface{}{"name": strings.Repeat("x", docLen)})
+	buf.Write(by)
+	by, _ = IndexBulkBytes("users", "user", "5", nil, map[string]interface{}{"name": "smurfs"})
+	buf.Write(by)
+	udp.MaxDatagram = docLen + 1
+	err = udp.Send(buf)
+	Assert(err != nil, t, "Should have error for the large doc")
+	d3 := <-datagrams
+	Assert(strings.Count(d3, "\n") == 2 && strings.Contains(d3, `"_id":"5"`), t, "Should have sent doc 5 %v", d3)
+}
+
+func TestBulkUDPIndexor(t *testing.T) {
+	conn, datagrams := udpListener(t)
+	defer conn.Close()
+	udp, _ := NewBulkUDP(conn.LocalAddr().String())
+	defer udp.Close()
+
+	indexor := NewBulkIndexor(2)
+	indexor.BulkSendor = udp.Send
+	done := make(chan bool)
+	indexor.Run(done)
+	defer close(done)
+
+	indexor.Index("users", "user", "1", nil, map[string]interface{}{"name": "smurfs"})
+	WaitFor(func() bool {
+		indexor.Flush()
+		return len(datagrams) > 0
+	}, 5)
+	select {
+	case d := <-datagrams:
+		Assert(strings.Contains(d, `"_id":"1"`), t, "Should have sent doc 1 %v", d)
+	case <-time.After(time.Second):
+		t.Fatal("Should have received a datagram")
+	}
+}
@@ -2,13 +2,24 @@ package core
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/json"
 	"flag"
+	"fmt"
 	u "github.com/araddon/gou"
 	"github.com/mattbaird/elastigo/api"
+	"hash/crc32"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -51,7 +62,7 @@ func TestBulk(t *testing.T) {
 	// part of request is url, so lets factor that in
 	//totalBytesSent = totalBytesSent - len(*eshost)
 	Assert(len(buffers) == 1, t, "Should have sent one operation but was %d", len(buffers))
-	Assert(BulkErrorCt == 0 && err == nil, t, "Should not have any errors  %v", err)
+	Assert(atomic.LoadUint64(&BulkErrorCt) == 0 && err == nil, t, "Should not have any errors  %v", err)
 	Assert(totalBytesSent == 145, t, "Should have sent 135 bytes but was %v", totalBytesSent)
 
 	err = indexor.Index("users", "user", "2", nil, data)
@@ -62,10 +73,7 @@ func TestBulk(t *testing.T) {
 	totalBytesSent = totalBytesSent - len(*eshost)
 	Assert(len(buffers) == 2, t, "Should have nil error, and another buffer")
 
-	Assert(BulkErrorCt == 0 && err == nil, t, "Should not have any errors")
-<<<<<<< HEAD
-	Assert(totalBytesSent == 257, t, "Should have sent 257 bytes but was %v", totalBytesSent)
-=======
+	Assert(atomic.LoadUint64(&BulkErrorCt) == 0 && err == nil, t, "Should not have any errors")
 	Assert(u.CloseInt(totalBytesSent, 257), t, "Should have sent 257 bytes but was %v", totalBytesSent)
 }
 
@@ -94,7 +102,570 @@ func TestBulkErrors(t *testing.T) {
 		break
 	}
 	u.Assert(errorCt > 0, t, "ErrorCt should be > 0 %d", errorCt)
->>>>>>> upstream/master
+}
+
+func TestBulkActionBytes(t *testing.T) {
+	by, err := IndexBulkBytes("users", "user", `quote"d`, nil, `{"name":"smurfs"}`)
+	Assert(err == nil, t, "Should not have error %v", err)
+	Assert(string(by) == `{"index":{"_index":"users","_type":"user","_id":"quote\"d"}}`+"\n"+`{"name":"smurfs"}`+"\n", t, "Should escape id %s", by)
+
+	date := time.Unix(1257894000, 0)
+	meta := BulkMeta{Index: "users", Type: "user", Id: "1"}
+	meta.SetTimestamp(&date)
+	by, _ = BulkActionBytes(BulkCreateAction, &meta, map[string]interface{}{"age": 22})
+	Assert(string(by) == `{"create":{"_index":"users","_type":"user","_id":"1","_timestamp":"1257894000000"}}`+"\n"+`{"age":22}`+"\n", t, "Wrong create %s", by)
+
+	by, _ = BulkActionBytes(BulkUpdateAction, &BulkMeta{Index: "users", Type: "user", Id: "1", RetryOnConflict: 3},
+		BulkUpdate{Script: "ctx._source.age += 1", Upsert: map[string]interface{}{"age": 1}})
+	Assert(string(by) == `{"update":{"_index":"users","_type":"user","_id":"1","_retry_on_conflict":3}}`+"\n"+`{"script":"ctx._source.age += 1","upsert":{"age":1}}`+"\n", t, "Wrong update %s", by)
+
+	by, _ = BulkActionBytes(BulkDeleteAction, &BulkMeta{Index: "users", Type: "user", Id: "1", Routing: "r1"}, nil)
+	Assert(string(by) == `{"delete":{"_index":"users","_type":"user","_id":"1","_routing":"r1"}}`+"\n", t, "Delete has no source %s", by)
+
+	_, err = BulkActionBytes(BulkIndexAction, &BulkMeta{Index: "users", Type: "user"}, nil)
+	Assert(err != nil, t, "Should have error for index without doc")
+	_, err = BulkActionBytes("upsert", &BulkMeta{Index: "users", Type: "user"}, "{}")
+	Assert(err != nil, t, "Should have error for unknown action")
+}
+
+// a fake elasticsearch _bulk answering each request with the next response
+func bulkServer(t *testing.T, sent chan string, responses ...string) func() {
+	return fakeServer(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprint(w, responses[0])
+		if len(responses) > 1 {
+			responses = responses[1:]
+		}
+		sent <- string(body)
+	})
+}
+
+func TestBulkItemRetry(t *testing.T) {
+	sent := make(chan string, 10)
+	defer bulkServer(t, sent,
+		`{"took":3,"items":[
+			{"index":{"_index":"users","_type":"user","_id":"1","_version":1,"ok":true}},
+			{"index":{"_index":"users","_type":"user","_id":"2","error":"RemoteTransportException[[es1][inet[/10.0.0.1:9300]][bulk/shard]]; nested: EsRejectedExecutionException[rejected execution of [org.elasticsearch.transport.netty.MessageChannelHandler$RequestHandler]]; "}},
+			{"create":{"_index":"users","_type":"user","_id":"3","status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse [age]"}}},
+			{"delete":{"_index":"users","_type":"user","_id":"4","status":503,"error":"UnavailableShardsException[[users][0] Primary shard is not active]"}}]}`,
+		`{"took":1,"errors":false,"items":[
+			{"index":{"_index":"users","_type":"user","_id":"2","status":201}},
+			{"delete":{"_index":"users","_type":"user","_id":"4","status":404,"found":false}}]}`)()
+
+	indexor := NewBulkIndexorErrors(1, 1)
+	done := make(chan bool)
+	indexor.Run(done)
+	defer close(done)
+
+	indexor.Index("users", "user", "1", nil, `{"age":1}`)
+	indexor.Index("users", "user", "2", nil, `{"age":2}`)
+	indexor.Create("users", "user", "3", nil, `{"age":"three"}`)
+	indexor.Delete("users", "user", "4")
+	WaitFor(func() bool {
+		indexor.mu.Lock()
+		defer indexor.mu.Unlock()
+		return indexor.bufferedDocs() == 4
+	}, 5)
+	indexor.Flush()
+
+	first := <-sent
+	Assert(strings.Count(first, "\n") == 7, t, "Should have sent all 4 items %s", first)
+
+	errBuf := <-indexor.ErrorChannel
+	itemErr, ok := errBuf.Err.(*BulkItemError)
+	Assert(ok && itemErr.Item.Id == "3" && itemErr.Item.Action == "create", t, "Should report the permanent failure %v", errBuf.Err)
+	Assert(strings.Contains(itemErr.Item.Error, "failed to parse"), t, "Should keep the error object %v", itemErr.Item.Error)
+	Assert(errBuf.Buf.String() == `{"create":{"_index":"users","_type":"user","_id":"3"}}`+"\n"+`{"age":"three"}`+"\n", t, "Should have the original doc %s", errBuf.Buf)
+
+	select {
+	case retry := <-sent:
+		Assert(retry == `{"index":{"_index":"users","_type":"user","_id":"2"}}`+"\n"+`{"age":2}`+"\n"+
+			`{"delete":{"_index":"users","_type":"user","_id":"4"}}`+"\n", t, "Should only retry the rejected and unavailable items %s", retry)
+	case <-time.After(time.Second * 5):
+		t.Fatal("Should have retried")
+	}
+	select {
+	case errBuf = <-indexor.ErrorChannel:
+		t.Fatalf("Should not have more errors %v", errBuf.Err)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestBulkClose(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	sent := 0
+	indexor := NewBulkIndexorErrors(2, 1)
+	indexor.BulkSendor = func(buf *bytes.Buffer) error {
+		time.Sleep(time.Millisecond * 10)
+		indexor.mu.Lock()
+		sent += strings.Count(buf.String(), "\n") / 2
+		indexor.mu.Unlock()
+		return nil
+	}
+	indexor.Run(make(chan bool))
+	for i := 0; i < 250; i++ {
+		indexor.Index("users", "user", strconv.Itoa(i), nil, `{"age":1}`)
+	}
+	err := indexor.Close(context.Background())
+	Assert(err == nil, t, "Should not have error %v", err)
+	Assert(sent == 250, t, "Should have sent all docs, including the partial buffer %d", sent)
+	err = indexor.Index("users", "user", "251", nil, `{"age":1}`)
+	Assert(err == ErrBulkIndexorClosed, t, "Should not accept docs once closed %v", err)
+	_, open := <-indexor.ErrorChannel
+	Assert(!open, t, "Should close the ErrorChannel")
+	Assert(indexor.Close(context.Background()) == nil, t, "Should close again")
+	WaitFor(func() bool {
+		return runtime.NumGoroutine() <= goroutines
+	}, 5)
+	Assert(runtime.NumGoroutine() <= goroutines, t, "Should not leak goroutines %d > %d", runtime.NumGoroutine(), goroutines)
+
+	// a close that times out drops what is not sent, failed sends are counted
+	release := make(chan bool)
+	indexor = NewBulkIndexorErrors(1, 1)
+	indexor.BulkSendor = func(buf *bytes.Buffer) error {
+		<-release
+		return fmt.Errorf("refused")
+	}
+	indexor.Run(make(chan bool))
+	for i := 0; i < 250; i++ {
+		indexor.Index("users", "user", strconv.Itoa(i), nil, `{"age":1}`)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	err = indexor.Close(ctx)
+	Assert(err != nil && strings.Contains(err.Error(), "not sent"), t, "Should time out %v", err)
+	close(release)
+	err = indexor.Close(context.Background())
+	Assert(err != nil && strings.Contains(err.Error(), "failed docs"), t, "Should count the failed docs %v", err)
+	WaitFor(func() bool {
+		return runtime.NumGoroutine() <= goroutines
+	}, 5)
+	Assert(runtime.NumGoroutine() <= goroutines, t, "Should not leak goroutines %d > %d", runtime.NumGoroutine(), goroutines)
+}
+
+func TestBulkConfig(t *testing.T) {
+	_, err := NewBulkIndexorConfig(1, WithMaxDocs(0))
+	Assert(err != nil, t, "Should not allow 0 docs")
+	_, err = NewBulkIndexorConfig(1, WithFlushInterval(time.Microsecond))
+	Assert(err != nil, t, "Should not allow an interval under 1ms")
+
+	sent := make(chan string, 10)
+	indexor, err := NewBulkIndexorConfig(1, WithMaxDocs(100), WithFlushInterval(time.Hour))
+	Assert(err == nil, t, "Should not have error %v", err)
+	indexor.BulkSendor = func(buf *bytes.Buffer) error {
+		sent <- buf.String()
+		return nil
+	}
+	indexor.Run(make(chan bool))
+	defer indexor.Close(context.Background())
+	docsBuffered := func(n int) func() bool {
+		return func() bool {
+			indexor.mu.Lock()
+			defer indexor.mu.Unlock()
+			return indexor.bufferedDocs() == n
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		indexor.Index("users", "user", strconv.Itoa(i), nil, `{"age":1}`)
+	}
+	WaitFor(docsBuffered(3), 5)
+	Assert(indexor.SetConfig(WithMaxDocs(-1)) != nil, t, "Should not apply an invalid config")
+	Assert(indexor.Config().MaxDocs == 100, t, "Should keep the config %v", indexor.Config())
+
+	// lowering the max sends the full buffer now
+	err = indexor.SetConfig(WithMaxDocs(2))
+	Assert(err == nil, t, "Should not have error %v", err)
+	select {
+	case buf := <-sent:
+		Assert(strings.Count(buf, "\n") == 6, t, "Should have sent the 3 docs %s", buf)
+	case <-time.After(time.Second):
+		t.Fatal("Should send once over the new max")
+	}
+
+	// sub second flushes
+	indexor.SetConfig(WithFlushInterval(20 * time.Millisecond))
+	indexor.Index("users", "user", "4", nil, `{"age":1}`)
+	select {
+	case buf := <-sent:
+		Assert(strings.Count(buf, "\n") == 2, t, "Should have sent 1 doc %s", buf)
+	case <-time.After(time.Second):
+		t.Fatal("Should flush after the new interval")
+	}
+}
+
+func TestBulkSpool(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "bulkspool")
+	defer os.RemoveAll(dir)
+
+	var down int32 = 1
+	var mu sync.Mutex
+	sent := make([]string, 0)
+	sendor := func(buf *bytes.Buffer) error {
+		if atomic.LoadInt32(&down) == 1 {
+			return fmt.Errorf("connection refused")
+		}
+		mu.Lock()
+		sent = append(sent, buf.String())
+		mu.Unlock()
+		return nil
+	}
+	spool, err := NewBulkSpool(dir, 0)
+	Assert(err == nil, t, "Should not have error %v", err)
+	spool.RetryInterval = 20 * time.Millisecond
+	indexor, _ := NewBulkIndexorConfig(1, WithMaxDocs(1))
+	indexor.BulkSendor = sendor
+	indexor.Spool = spool
+	indexor.Run(make(chan bool))
+	for i := 1; i <= 3; i++ {
+		indexor.Index("users", "user", strconv.Itoa(i), nil, `{"age":1}`)
+	}
+	WaitFor(func() bool {
+		return spool.Len() == 3
+	}, 5)
+	err = indexor.Close(context.Background())
+	Assert(err != nil && spool.Len() == 3, t, "Should leave the buffers in the spool %v %d", err, spool.Len())
+
+	// a new process picks them up, and sends them in order once the cluster is back
+	spool, err = NewBulkSpool(dir, 0)
+	Assert(err == nil && spool.Len() == 3, t, "Should reopen the spool %v %d", err, spool.Len())
+	spool.RetryInterval = 20 * time.Millisecond
+	indexor, _ = NewBulkIndexorConfig(1, WithMaxDocs(1))
+	indexor.BulkSendor = sendor
+	indexor.Spool = spool
+	indexor.Run(make(chan bool))
+	atomic.StoreInt32(&down, 0)
+	WaitFor(func() bool {
+		return spool.Len() == 0
+	}, 5)
+	indexor.Index("users", "user", "4", nil, `{"age":1}`)
+	err = indexor.Close(context.Background())
+	Assert(err == nil, t, "Should not have error %v", err)
+	Assert(len(sent) == 4, t, "Should have sent all 4 %v", sent)
+	for i, buf := range sent {
+		Assert(strings.Contains(buf, `"_id":"`+strconv.Itoa(i+1)+`"`), t, "Should send in order %v", sent)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	Assert(len(files) == 0 && spool.Size() == 0, t, "Should have emptied the spool %v", files)
+
+	small, _ := NewBulkSpool(dir, 10)
+	Assert(small.Put([]byte(`{"delete":{"_index":"users","_type":"user","_id":"1"}}`)) == ErrBulkSpoolFull, t, "Should limit the size")
+}
+
+func TestBulkStats(t *testing.T) {
+	sent := make(chan string, 10)
+	defer bulkServer(t, sent,
+		`{"took":3,"items":[
+			{"index":{"_index":"users","_type":"user","_id":"1","_version":1,"ok":true}},
+			{"index":{"_index":"users","_type":"user","_id":"2","error":"MapperParsingException[failed to parse [age]]"}}]}`,
+		`{"took":1,"items":[{"index":{"_index":"users","_type":"user","_id":"3","_version":1,"ok":true}}]}`)()
+
+	indexor, _ := NewBulkIndexorConfig(1, WithMaxDocs(2))
+	var mu sync.Mutex
+	before, results, failures := 0, make([]BulkSendResult, 0), make([]string, 0)
+	indexor.BeforeSend = func(data []byte) {
+		mu.Lock()
+		before++
+		mu.Unlock()
+	}
+	indexor.AfterSend = func(result BulkSendResult, took time.Duration) {
+		mu.Lock()
+		results = append(results, result)
+		mu.Unlock()
+	}
+	indexor.OnItemFailure = func(item BulkItemResult, data []byte) {
+		mu.Lock()
+		failures = append(failures, item.Id+" "+string(data))
+		mu.Unlock()
+	}
+	indexor.Run(make(chan bool))
+	indexor.Index("users", "user", "1", nil, `{"age":1}`)
+	indexor.Index("users", "user", "2", nil, `{"age":"two"}`)
+	indexor.Index("users", "user", "3", nil, `{"age":3}`)
+	err := indexor.Close(context.Background())
+	Assert(err != nil, t, "Should report the failed doc")
+
+	stats := indexor.Stats()
+	Assert(stats.Docs == 3 && stats.Batches == 2 && stats.Errors == 1 && stats.SendErrors == 1, t, "Wrong counts %v", stats)
+	Assert(stats.Bytes > 0 && stats.MaxTime > 0 && stats.TotalTime >= stats.MaxTime, t, "Should count bytes and time %v", stats)
+	Assert(stats.QueuedDocs == 0 && stats.QueuedBatches == 0, t, "Should have nothing queued %v", stats)
+	Assert(before == 2 && len(results) == 2, t, "Should call the send hooks %d %v", before, results)
+	_, partial := results[0].Err.(*BulkItemsError)
+	Assert(partial && results[0].Docs == 2 && results[1].Err == nil, t, "Wrong results %v", results)
+	Assert(len(failures) == 1 && strings.HasPrefix(failures[0], `2 {"index":{"_index":"users","_type":"user","_id":"2"}}`), t, "Should have the failed item %v", failures)
+}
+
+func TestBulkAdaptive(t *testing.T) {
+	_, err := NewBulkIndexorConfig(4, WithAdaptive(&BulkAdaptive{MinDocs: 10, MaxDocs: 5}))
+	Assert(err != nil, t, "Should not allow min over max")
+
+	indexor, err := NewBulkIndexorConfig(4, WithMaxDocs(10), WithFlushInterval(time.Hour),
+		WithAdaptive(&BulkAdaptive{MinDocs: 2, MaxDocs: 50, MinConns: 1, TargetLatency: time.Second}))
+	Assert(err == nil, t, "Should not have error %v", err)
+	rejected := &BulkItemsError{BulkResponse{Items: []BulkItemResult{{Status: 429, Error: "EsRejectedExecutionException[rejected execution]"}}}}
+
+	indexor.adapt(nil, time.Millisecond)
+	stats := indexor.Stats()
+	Assert(stats.BatchDocs == 12 && stats.Conns == 4, t, "Should grow the batch when fast %v", stats)
+	indexor.adapt(rejected, time.Millisecond)
+	stats = indexor.Stats()
+	Assert(stats.BatchDocs == 6 && stats.Conns == 2, t, "Should halve when rejected %v", stats)
+	indexor.adapt(nil, 2*time.Second)
+	Assert(indexor.Stats().BatchDocs == 5, t, "Should shrink the batch when slow %v", indexor.Stats())
+	indexor.adapt(fmt.Errorf("Error [EsRejectedExecutionException[rejected]] Status [429]"), time.Millisecond)
+	indexor.adapt(rejected, time.Millisecond)
+	stats = indexor.Stats()
+	Assert(stats.BatchDocs == 2 && stats.Conns == 1, t, "Should stay within bounds %v", stats)
+
+	// with one request allowed in flight, the other sendors wait
+	var active, maxActive int32
+	indexor.BulkSendor = func(buf *bytes.Buffer) error {
+		n := atomic.AddInt32(&active, 1)
+		if n > atomic.LoadInt32(&maxActive) {
+			atomic.StoreInt32(&maxActive, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&active, -1)
+		return rejected
+	}
+	indexor.Run(make(chan bool))
+	for i := 0; i < 20; i++ {
+		indexor.Index("users", "user", strconv.Itoa(i), nil, `{"age":1}`)
+	}
+	indexor.Close(context.Background())
+	Assert(maxActive == 1, t, "Should only have 1 request in flight %d", maxActive)
+	Assert(indexor.Stats().Batches == 10, t, "Should send batches of 2 %v", indexor.Stats())
+
+	// changing other settings keeps what has been adapted, a lower MaxDocs caps it
+	indexor.adapt(nil, time.Millisecond)
+	indexor.SetConfig(WithMaxDocs(20), WithAdaptive(&BulkAdaptive{MinDocs: 2, MaxDocs: 50, MinConns: 1, TargetLatency: time.Second}))
+	stats = indexor.Stats()
+	Assert(stats.BatchDocs == 3 && stats.Conns == 2, t, "Should keep the adapted sizes %v", stats)
+	indexor.adapt(nil, time.Millisecond)
+	indexor.adapt(nil, time.Millisecond)
+	indexor.SetConfig(WithMaxDocs(4))
+	stats = indexor.Stats()
+	Assert(stats.BatchDocs == 4 && stats.Conns == 4, t, "Should cap the adapted batch %v", stats)
+	indexor.SetConfig(WithMaxDocs(1))
+	Assert(indexor.Stats().BatchDocs == 2, t, "Should stay within bounds %v", indexor.Stats())
+	indexor.SetConfig(WithMaxDocs(3), WithAdaptive(&BulkAdaptive{MinDocs: 1, MaxDocs: 50, MinConns: 1, TargetLatency: time.Second}))
+	Assert(indexor.Stats().BatchDocs == 3, t, "Should start over from MaxDocs %v", indexor.Stats())
+}
+
+func TestBulkOrdered(t *testing.T) {
+	indexor, _ := NewBulkIndexorConfig(4, WithMaxDocs(1))
+	indexor.Ordered = true
+	var mu sync.Mutex
+	var active, maxActive int32
+	versions := make(map[string][]int)
+	indexor.BulkSendor = func(buf *bytes.Buffer) error {
+		n := atomic.AddInt32(&active, 1)
+		if n > atomic.LoadInt32(&maxActive) {
+			atomic.StoreInt32(&maxActive, n)
+		}
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		var meta map[string]BulkMeta
+		var doc map[string]int
+		json.Unmarshal([]byte(lines[0]), &meta)
+		json.Unmarshal([]byte(lines[1]), &doc)
+		id := meta["index"].Id
+		// every other version of a doc is slow, so the next one would overtake it
+		if (doc["v"]+int(id[0]))%2 == 0 {
+			time.Sleep(15 * time.Millisecond)
+		}
+		mu.Lock()
+		versions[id] = append(versions[id], doc["v"])
+		mu.Unlock()
+		atomic.AddInt32(&active, -1)
+		return nil
+	}
+	indexor.Run(make(chan bool))
+	for v := 0; v < 10; v++ {
+		for _, id := range []string{"a", "b", "c", "d", "e", "f"} {
+			indexor.Index("users", "user", id, nil, fmt.Sprintf(`{"v":%d}`, v))
+		}
+	}
+	err := indexor.Close(context.Background())
+	Assert(err == nil, t, "Should not have error %v", err)
+	for id, vs := range versions {
+		Assert(len(vs) == 10, t, "Should have sent all versions of %s %v", id, vs)
+		for i, v := range vs {
+			Assert(v == i, t, "Should have sent %s in order %v", id, vs)
+		}
+	}
+	Assert(maxActive > 1, t, "Should still send different docs in parallel %d", maxActive)
+}
+
+func TestBulkOrderedStalledLane(t *testing.T) {
+	indexor, _ := NewBulkIndexorConfig(2, WithMaxDocs(1))
+	indexor.Ordered = true
+	// two docs in different lanes
+	stalledId, otherId := "a", ""
+	for _, id := range []string{"b", "c", "d", "e", "f"} {
+		if crc32.ChecksumIEEE([]byte(bulkKey("users", id)))%2 != crc32.ChecksumIEEE([]byte(bulkKey("users", stalledId)))%2 {
+			otherId = id
+			break
+		}
+	}
+	stall := make(chan bool)
+	var sent int32
+	indexor.BulkSendor = func(buf *bytes.Buffer) error {
+		if strings.Contains(buf.String(), `"_id":"`+stalledId+`"`) {
+			<-stall
+		}
+		atomic.AddInt32(&sent, 1)
+		return nil
+	}
+	indexor.Run(make(chan bool))
+	go func() {
+		for i := 0; i < 10; i++ {
+			indexor.Index("users", "user", stalledId, nil, `{"age":1}`)
+		}
+	}()
+
+	// the other lane keeps going, well past what the doc channel holds
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 300; i++ {
+			indexor.Index("users", "user", otherId, nil, `{"age":1}`)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Should not block the other lane behind a stalled one")
+	}
+	for i := 0; atomic.LoadInt32(&sent) < 300 && i < 500; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	Assert(atomic.LoadInt32(&sent) == 300, t, "Should have sent the other lane %d", sent)
+
+	close(stall)
+	err := indexor.Close(context.Background())
+	Assert(err == nil && atomic.LoadInt32(&sent) == 310, t, "Should have sent all once unstalled %d %v", sent, err)
+}
+
+func TestBulkMaxInFlight(t *testing.T) {
+	release := make(chan bool)
+	indexor, err := NewBulkIndexorConfig(1, WithMaxBuffer(200), WithMaxInFlightBytes(400), WithFlushInterval(time.Hour))
+	Assert(err == nil, t, "Should not have error %v", err)
+	_, err = NewBulkIndexorConfig(1, WithMaxBuffer(200), WithMaxInFlightBytes(100))
+	Assert(err != nil, t, "Should not allow a limit under MaxBuffer")
+	indexor.BulkSendor = func(buf *bytes.Buffer) error {
+		<-release
+		return nil
+	}
+	indexor.Run(make(chan bool))
+
+	var added int32
+	go func() {
+		for i := 0; i < 100; i++ {
+			indexor.Index("users", "user", strconv.Itoa(i), nil, `{"name":"smurfs"}`)
+			atomic.AddInt32(&added, 1)
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	stats := indexor.Stats()
+	Assert(atomic.LoadInt32(&added) < 10, t, "Should block Index when full %d", added)
+	Assert(stats.InFlightBytes > 0 && stats.InFlightBytes <= 400, t, "Should keep under the limit %v", stats)
+
+	close(release)
+	WaitFor(func() bool {
+		return atomic.LoadInt32(&added) == 100
+	}, 5)
+	err = indexor.Close(context.Background())
+	Assert(err == nil && indexor.Stats().Docs == 100, t, "Should send all docs %v %v", err, indexor.Stats())
+	Assert(indexor.Stats().InFlightBytes == 0, t, "Should release all bytes %v", indexor.Stats())
+}
+
+func TestBulkMaxInFlightClose(t *testing.T) {
+	indexor, _ := NewBulkIndexorConfig(2, WithMaxBuffer(200), WithMaxInFlightBytes(200), WithFlushInterval(time.Hour))
+	var sent int32
+	indexor.BulkSendor = func(buf *bytes.Buffer) error {
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&sent, int32(len(bulkItems(buf.Bytes()))))
+		return nil
+	}
+	indexor.Run(make(chan bool))
+
+	// the producers wait for room as the indexor closes
+	var added int32
+	var wg sync.WaitGroup
+	for p := 0; p < 4; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				if indexor.Index("users", "user", fmt.Sprintf("%d-%d", p, i), nil, `{"name":"smurfs"}`) != nil {
+					return
+				}
+				atomic.AddInt32(&added, 1)
+			}
+		}(p)
+	}
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := indexor.Close(ctx)
+	wg.Wait()
+	Assert(err == nil, t, "Should close without waiting on the producers %v", err)
+	Assert(atomic.LoadInt32(&added) > 0 && atomic.LoadInt32(&sent) == atomic.LoadInt32(&added), t, "Should send every doc added %d %d", added, sent)
+}
+
+func TestBulkSendorKeepsBuffer(t *testing.T) {
+	indexor, _ := NewBulkIndexorConfig(2, WithMaxDocs(1))
+	var mu sync.Mutex
+	kept := make([]*bytes.Buffer, 0)
+	indexor.BulkSendor = func(buf *bytes.Buffer) error {
+		mu.Lock()
+		kept = append(kept, buf)
+		mu.Unlock()
+		return nil
+	}
+	indexor.Run(make(chan bool))
+	// one at a time, so the next doc gets the buffer just sent from the pool
+	for i := 0; i < 50; i++ {
+		indexor.Index("users", "user", strconv.Itoa(i), nil, `{"name":"smurfs"}`)
+		for indexor.Stats().InFlightBytes > 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	indexor.Close(context.Background())
+
+	// the pooled buffers are reused, but not those handed to the sendor
+	ids := make(map[string]bool)
+	for _, buf := range kept {
+		var meta map[string]BulkMeta
+		json.Unmarshal(bytes.SplitN(buf.Bytes(), []byte("\n"), 2)[0], &meta)
+		ids[meta["index"].Id] = true
+	}
+	Assert(len(kept) == 50 && len(ids) == 50, t, "Should keep every doc sent %d %d", len(kept), len(ids))
+}
+
+func TestBulkSendTo(t *testing.T) {
+	status, body := 200, ""
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		by, _ := ioutil.ReadAll(r.Body)
+		body = r.URL.Path + " " + string(by)
+		w.WriteHeader(status)
+		if status == 200 {
+			fmt.Fprint(w, `{"took":1,"items":[{"index":{"_index":"users","_type":"user","_id":"1","error":"MapperParsingException"}}]}`)
+		} else {
+			fmt.Fprint(w, `{"error":"EsRejectedExecutionException","status":429}`)
+		}
+	}))
+	defer ts.Close()
+
+	send := BulkSendTo(ts.URL + "/")
+	err := send(bytes.NewBufferString(`{"index":{"_index":"users","_type":"user","_id":"1"}}` + "\n{}\n"))
+	Assert(body == `/_bulk {"index":{"_index":"users","_type":"user","_id":"1"}}`+"\n{}\n", t, "Wrong request %s", body)
+	itemsErr, ok := err.(*BulkItemsError)
+	Assert(ok && len(itemsErr.Response.Failed()) == 1, t, "Should have the failed item %v", err)
+
+	status = 429
+	err = send(bytes.NewBufferString("{}\n"))
+	Assert(err != nil && bulkRejected(err), t, "Should be rejected %v", err)
 }
 
 /*
@@ -123,7 +694,7 @@ func BenchmarkBulkSend(b *testing.B) {
 		IndexBulk("users", "user", strconv.Itoa(i), nil, data)
 	}
 	log.Printf("Sent %d messages in %d sets totaling %d bytes \n", b.N, sets, totalBytes)
-	if BulkErrorCt != 0 {
+	if atomic.LoadUint64(&BulkErrorCt) != 0 {
 		b.Fail()
 	}
 }
@@ -155,7 +726,53 @@ func BenchmarkBulkSendBytes(b *testing.B) {
 		IndexBulk("users", "user", strconv.Itoa(i), nil, body)
 	}
 	log.Printf("Sent %d messages in %d sets totaling %d bytes \n", b.N, sets, totalBytes)
-	if BulkErrorCt != 0 {
+	if atomic.LoadUint64(&BulkErrorCt) != 0 {
 		b.Fail()
 	}
 }
+
+/*
+Allocations of the whole indexor hot path, with a sendor that does nothing
+  go test -bench="BulkIndexor" -benchtime 20000x
+
+before pooled buffers and the streaming encoder:
+BenchmarkBulkIndexor      	   20000	      8688 ns/op	    7489 B/op	      22 allocs/op
+BenchmarkBulkIndexorBytes 	   20000	      3862 ns/op	    4824 B/op	       8 allocs/op
+
+after, as the sendor here is set by the caller each request is copied for it, a
+built in sendor sends the pooled buffer as is:
+BenchmarkBulkIndexor      	   20000	      5724 ns/op	    3768 B/op	      17 allocs/op
+BenchmarkBulkIndexorBytes 	   20000	      1712 ns/op	    2874 B/op	       4 allocs/op
+
+*/
+func BenchmarkBulkIndexor(b *testing.B) {
+	about := make([]byte, 1000)
+	rand.Read(about)
+	data := map[string]interface{}{"name": "smurfs", "age": 22, "date": time.Unix(1257894000, 0), "about": about}
+	indexor, _ := NewBulkIndexorConfig(4, WithFlushInterval(time.Hour))
+	indexor.BulkSendor = func(buf *bytes.Buffer) error {
+		return nil
+	}
+	indexor.Run(make(chan bool))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		indexor.Index("users", "user", strconv.Itoa(i), nil, data)
+	}
+	indexor.Close(context.Background())
+}
+
+func BenchmarkBulkIndexorBytes(b *testing.B) {
+	body := []byte(`{"name":"smurfs","age":22,"about":"` + strings.Repeat("a", 1000) + `"}`)
+	indexor, _ := NewBulkIndexorConfig(4, WithFlushInterval(time.Hour))
+	indexor.BulkSendor = func(buf *bytes.Buffer) error {
+		return nil
+	}
+	indexor.Run(make(chan bool))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		indexor.Index("users", "user", strconv.Itoa(i), nil, body)
+	}
+	indexor.Close(context.Background())
+}
@@ -2,33 +2,88 @@ package core
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/mattbaird/elastigo/api"
+	"net/url"
+	"strings"
 )
 
-// The explain api computes a score explanation for a query and a specific document. 
-// This can give useful feedback whether a document matches or didn’t match a specific query. 
+// The explain api computes a score explanation for a query and a specific document.
+// This can give useful feedback whether a document matches or didn’t match a specific query.
 // This feature is available from version 0.19.9 and up.
+//
+// params:
+//   @query:  this can be one of 2 types:
+//              1)  string value that is a lucene query string (sent as the q parameter)
+//              2)  other type marshalable to json such as search.QueryDsl (the query clause,
+//                  use json.RawMessage for raw json)
+//   @opts:  optional routing, preference and fields
+//
+//   out, err := Explain(true, "github", "PushEvent", "1234", "actor:kimchy", ExplainOptions{})
+//   log.Println(out.Explanation.String())
+//
 // see http://www.elasticsearch.org/guide/reference/api/explain.html
-func Explain(pretty bool, index string, _type string, id string, query string) (api.Match, error) {
-	var url string
-	var retval api.Match
-	if len(_type) > 0 {
-		url = fmt.Sprintf("/%s/%s/_explain?%s", index, _type, api.Pretty(pretty))
-	} else {
-		url = fmt.Sprintf("/%s/_explain?%s", index, api.Pretty(pretty))
+func Explain(pretty bool, index string, _type string, id string, query interface{}, opts ExplainOptions) (ExplainResult, error) {
+	var retval ExplainResult
+	var body interface{}
+	args := opts.urlArgs()
+	if pretty {
+		args.Set("pretty", "1")
 	}
-	body, err := api.DoCommand("GET", url, query)
+	switch q := query.(type) {
+	case nil:
+		return retval, errors.New("explain needs a query")
+	case string:
+		args.Set("q", q)
+	default:
+		body = map[string]interface{}{"query": q}
+	}
+	url := fmt.Sprintf("/%s/%s/%s/_explain?%s", index, _type, id, args.Encode())
+	resp, err := api.DoCommand("GET", url, body)
 	if err != nil {
 		return retval, err
 	}
-	if err == nil {
-		// marshall into json
-		jsonErr := json.Unmarshal(body, &retval)
-		if jsonErr != nil {
-			return retval, jsonErr
-		}
-	}
-	fmt.Println(body)
+	// marshall into json
+	err = json.Unmarshal(resp, &retval)
 	return retval, err
 }
+
+// The optional arguments of an explain request
+type ExplainOptions struct {
+	// Routing value used when indexing the document
+	Routing string
+	// Controls which shard the explain is executed on
+	Preference string
+	// Stored fields of the document to return along with the explanation
+	Fields []string
+}
+
+func (o *ExplainOptions) urlArgs() url.Values {
+	args := url.Values{}
+	if len(o.Routing) > 0 {
+		args.Set("routing", o.Routing)
+	}
+	if len(o.Preference) > 0 {
+		args.Set("preference", o.Preference)
+	}
+	if len(o.Fields) > 0 {
+		args.Set("fields", strings.Join(o.Fields, ","))
+	}
+	return args
+}
+
+type ExplainResult struct {
+	Ok          bool             `json:"ok"`
+	Index       string           `json:"_index"`
+	Type        string           `json:"_type"`
+	Id          string           `json:"_id"`
+	Matched     bool             `json:"matched"`
+	Explanation api.Explaination `json:"explanation"`
+	Get         *ExplainGet      `json:"get,omitempty"` // only if fields were requested
+}
+
+type ExplainGet struct {
+	Fields map[string]interface{} `json:"fields,omitempty"`
+	Source json.RawMessage        `json:"_source,omitempty"`
+}
@@ -0,0 +1,41 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	var url, body string
+	defer fakeServer(func(w http.ResponseWriter, r *http.Request) {
+		by, _ := ioutil.ReadAll(r.Body)
+		url, body = r.URL.RequestURI(), string(by)
+		fmt.Fprint(w, `{"ok":true,"_index":"github","_type":"PushEvent","_id":"1","matched":true,
+			"explanation":{"value":0.5,"description":"product of:","details":[
+				{"value":1,"description":"tf(termFreq(actor:kimchy)=1)"},
+				{"value":0.5,"description":"fieldNorm(field=actor, doc=1)","details":[{"value":0.5,"description":"norm"}]}]}}`)
+	})()
+
+	qry := map[string]interface{}{"term": map[string]string{"actor": "kimchy"}}
+	out, err := Explain(false, "github", "PushEvent", "1", qry, ExplainOptions{Routing: "r1"})
+	Assert(err == nil, t, "Should not have error %v", err)
+	Assert(url == "/github/PushEvent/1/_explain?routing=r1", t, "Wrong url %s", url)
+	Assert(body == `{"query":{"term":{"actor":"kimchy"}}}`, t, "Wrong body %s", body)
+	Assert(out.Matched && out.Id == "1", t, "Wrong result %v", out)
+
+	expected := "0.5 = product of:\n" +
+		"  1 = tf(termFreq(actor:kimchy)=1)\n" +
+		"  0.5 = fieldNorm(field=actor, doc=1)\n" +
+		"    0.5 = norm\n"
+	Assert(out.Explanation.String() == expected, t, "Wrong explanation\n%s", out.Explanation.String())
+	Assert(fmt.Sprint(out.Explanation) == expected, t, "Should print as the tree\n%s", fmt.Sprint(out.Explanation))
+
+	_, err = Explain(false, "github", "PushEvent", "1", "actor:kimchy", ExplainOptions{})
+	Assert(err == nil && url == "/github/PushEvent/1/_explain?q=actor%3Akimchy" && body == "", t, "Wrong query string request %s %s", url, body)
+
+	url = ""
+	_, err = Explain(false, "github", "PushEvent", "1", nil, ExplainOptions{})
+	Assert(err != nil && url == "", t, "Should not send an explain without a query")
+}
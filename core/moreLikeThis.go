@@ -4,15 +4,26 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/mattbaird/elastigo/api"
+	"net/url"
+	"strconv"
+	"strings"
 )
 
-// The more like this (mlt) API allows to get documents that are “like” a specified document. 
+// The more like this (mlt) API allows to get documents that are “like” a specified document.
+// The result is a normal search result, the optional @search body (string, io.Reader or anything
+// json marshalable, nil for none) is used to filter/facet the search that is run.
+//
+//   out, err := MoreLikeThis(true, "github", "PushEvent", "1234", MLT{Fields: []string{"repository.description"}, MinTermFrequency: 1}, nil)
+//
 // http://www.elasticsearch.org/guide/reference/api/more-like-this.html
-func MoreLikeThis(pretty bool, index string, _type string, id string, query MoreLikeThisQuery) (api.BaseResponse, error) {
+func MoreLikeThis(pretty bool, index string, _type string, id string, mlt MLT, search interface{}) (SearchResult, error) {
 	var url string
-	var retval api.BaseResponse
+	var retval SearchResult
 	url = fmt.Sprintf("/%s/%s/%s/_mlt?%s", index, _type, id, api.Pretty(pretty))
-	body, err := api.DoCommand("GET", url, query)
+	if args := mlt.urlArgs(); len(args) > 0 {
+		url += "&" + args.Encode()
+	}
+	body, err := api.DoCommand("GET", url, search)
 	if err != nil {
 		return retval, err
 	}
@@ -23,26 +34,89 @@ func MoreLikeThis(pretty bool, index string, _type string, id string, query More
 			return retval, jsonErr
 		}
 	}
-	fmt.Println(body)
 	return retval, err
 }
 
+// The more_like_this query clause, for use inside of a query body
 type MoreLikeThisQuery struct {
 	MoreLikeThis MLT `json:"more_like_this"`
 }
 
+// The more like this parameters, all are optional and only sent if set.  When
+// serialized to json this is the body of a more_like_this query, the Search* fields
+// only apply to the _mlt api.
 type MLT struct {
-	Fields              []string `json:"fields"`
-	LikeText            string   `json:"like_text"`
-	PercentTermsToMatch float32  `json:"percent_terms_to_match"`
-	MinTermFrequency    int      `json:"min_term_freq"`
-	MaxQueryTerms       int      `json:"max_query_terms"`
-	StopWords           []string `json:"stop_words"`
-	MinDocFrequency     int      `json:"min_doc_freq"`
-	MaxDocFrequency     int      `json:"max_doc_freq"`
-	MinWordLength       int      `json:"min_word_len"`
-	MaxWordLength       int      `json:"max_word_len"`
-	BoostTerms          int      `json:"boost_terms"`
-	Boost               float32  `json:"boost"`
-	Analyzer            string   `json:"analyzer"`
+	Fields              []string `json:"fields,omitempty"`
+	LikeText            string   `json:"like_text,omitempty"`
+	PercentTermsToMatch float32  `json:"percent_terms_to_match,omitempty"`
+	MinTermFrequency    int      `json:"min_term_freq,omitempty"`
+	MaxQueryTerms       int      `json:"max_query_terms,omitempty"`
+	StopWords           []string `json:"stop_words,omitempty"`
+	MinDocFrequency     int      `json:"min_doc_freq,omitempty"`
+	MaxDocFrequency     int      `json:"max_doc_freq,omitempty"`
+	MinWordLength       int      `json:"min_word_len,omitempty"`
+	MaxWordLength       int      `json:"max_word_len,omitempty"`
+	BoostTerms          float32  `json:"boost_terms,omitempty"`
+	Boost               float32  `json:"boost,omitempty"`
+	Analyzer            string   `json:"analyzer,omitempty"`
+
+	// Indices, types and paging of the search run by the _mlt api
+	SearchIndices []string `json:"-"`
+	SearchTypes   []string `json:"-"`
+	SearchFrom    int      `json:"-"`
+	SearchSize    int      `json:"-"`
+}
+
+// The _mlt api takes its parameters on the url rather than in the body
+func (m *MLT) urlArgs() url.Values {
+	args := url.Values{}
+	if len(m.Fields) > 0 {
+		args.Set("mlt_fields", strings.Join(m.Fields, ","))
+	}
+	if m.PercentTermsToMatch != 0 {
+		args.Set("percent_terms_to_match", strconv.FormatFloat(float64(m.PercentTermsToMatch), 'f', -1, 32))
+	}
+	if m.MinTermFrequency != 0 {
+		args.Set("min_term_freq", strconv.Itoa(m.MinTermFrequency))
+	}
+	if m.MaxQueryTerms != 0 {
+		args.Set("max_query_terms", strconv.Itoa(m.MaxQueryTerms))
+	}
+	if len(m.StopWords) > 0 {
+		args.Set("stop_words", strings.Join(m.StopWords, ","))
+	}
+	if m.MinDocFrequency != 0 {
+		args.Set("min_doc_freq", strconv.Itoa(m.MinDocFrequency))
+	}
+	if m.MaxDocFrequency != 0 {
+		args.Set("max_doc_freq", strconv.Itoa(m.MaxDocFrequency))
+	}
+	if m.MinWordLength != 0 {
+		args.Set("min_word_len", strconv.Itoa(m.MinWordLength))
+	}
+	if m.MaxWordLength != 0 {
+		args.Set("max_word_len", strconv.Itoa(m.MaxWordLength))
+	}
+	if m.BoostTerms != 0 {
+		args.Set("boost_terms", strconv.FormatFloat(float64(m.BoostTerms), 'f', -1, 32))
+	}
+	if m.Boost != 0 {
+		args.Set("boost", strconv.FormatFloat(float64(m.Boost), 'f', -1, 32))
+	}
+	if len(m.Analyzer) > 0 {
+		args.Set("analyzer", m.Analyzer)
+	}
+	if len(m.SearchIndices) > 0 {
+		args.Set("search_indices", strings.Join(m.SearchIndices, ","))
+	}
+	if len(m.SearchTypes) > 0 {
+		args.Set("search_types", strings.Join(m.SearchTypes, ","))
+	}
+	if m.SearchFrom != 0 {
+		args.Set("search_from", strconv.Itoa(m.SearchFrom))
+	}
+	if m.SearchSize != 0 {
+		args.Set("search_size", strconv.Itoa(m.SearchSize))
+	}
+	return args
 }
@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/url"
 	"strconv"
+	"strings"
 )
 
 var (
@@ -105,6 +106,13 @@ func Scroll(pretty bool, scroll_id string, scroll string) (SearchResult, error)
 	return retval, err
 }
 
+// Clear the search context of one or more scroll ids, freeing the resources held
+// on the server before the keep alive times out
+func ClearScroll(scrollIds ...string) error {
+	_, err := api.DoCommand("DELETE", "/_search/scroll", strings.Join(scrollIds, ","))
+	return err
+}
+
 type SearchResult struct {
 	Took        int             `json:"took"`
 	TimedOut    bool            `json:"timed_out"`
@@ -129,11 +137,31 @@ func (h *Hits) Len() int {
 }
 
 type Hit struct {
-	Index  string          `json:"_index"`
-	Type   string          `json:"_type,omitempty"`
-	Id     string          `json:"_id"`
-	Score  Float32Nullable `json:"_score,omitempty"` // Filters (no query) dont have score, so is null
-	Source json.RawMessage `json:"_source"`          // marshalling left to consumer
+	Index  string                 `json:"_index"`
+	Type   string                 `json:"_type,omitempty"`
+	Id     string                 `json:"_id"`
+	Score  Float32Nullable        `json:"_score,omitempty"` // Filters (no query) dont have score, so is null
+	Source json.RawMessage        `json:"_source"`          // marshalling left to consumer
+	Fields map[string]interface{} `json:"fields,omitempty"` // only the requested fields
+	Sort   []interface{}          `json:"sort,omitempty"`   // values sorted on, such as a geo distance
+}
+
+// The value of a field of this hit that was requested with fields (such as "_routing"
+// or "_parent") as a string, "" if missing
+func (h *Hit) StringField(name string) string {
+	switch v := h.Fields[name].(type) {
+	case string:
+		return v
+	case []interface{}:
+		if len(v) > 0 {
+			return fmt.Sprint(v[0])
+		}
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+	return ""
 }
 
 // Elasticsearch returns some invalid (according to go) json, with floats having...
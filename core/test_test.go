@@ -9,7 +9,10 @@ import (
 	"github.com/mattbaird/elastigo/api"
 	"hash/crc32"
 	"log"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"strconv"
 	"testing"
@@ -144,3 +147,16 @@ func LoadTestData() {
 	// lets wait a bit to ensure that elasticsearch finishes?
 	time.Sleep(time.Second * 5)
 }
+
+// point the api at a fake elasticsearch, returns the func to restore it
+func fakeServer(handler http.HandlerFunc) func() {
+	ts := httptest.NewServer(handler)
+	addr, _ := url.Parse(ts.URL)
+	host, port, _ := net.SplitHostPort(addr.Host)
+	domain, origPort := api.Domain, api.Port
+	api.Domain, api.Port = host, port
+	return func() {
+		api.Domain, api.Port = domain, origPort
+		ts.Close()
+	}
+}
@@ -4,19 +4,47 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/mattbaird/elastigo/api"
+	"net/url"
+	"strconv"
 )
 
 // The validate API allows a user to validate a potentially expensive query without executing it.
+// The query here is a raw (lucene syntax) query string, see ValidateQuery for query dsl bodies.
+//
+//   v, err := Validate(true, "github", "", "actor:a* AND type:PushEvent", true)
+//
 // see http://www.elasticsearch.org/guide/reference/api/validate.html
-func Validate(pretty bool, index string, _type string, query string, explain bool) (api.BaseResponse, error) {
-	var url string
-	var retval api.BaseResponse
+func Validate(pretty bool, index string, _type string, query string, explain bool) (Validation, error) {
+	args := url.Values{}
+	args.Set("q", query)
+	return doValidate(validateUrl(pretty, index, _type, args, explain), nil)
+}
+
+// Validate a query dsl body without executing it.  The @query can be a string, io.Reader
+// or anything json marshalable (such as search.QueryDsl), and is the query clause itself.
+//
+//   qry := map[string]interface{}{"term": map[string]string{"actor": "kimchy"}}
+//   v, err := ValidateQuery(true, "github", "", qry, true)
+func ValidateQuery(pretty bool, index string, _type string, query interface{}, explain bool) (Validation, error) {
+	return doValidate(validateUrl(pretty, index, _type, url.Values{}, explain), query)
+}
+
+func validateUrl(pretty bool, index string, _type string, args url.Values, explain bool) string {
+	if pretty {
+		args.Set("pretty", "1")
+	}
+	if explain {
+		args.Set("explain", strconv.FormatBool(explain))
+	}
 	if len(_type) > 0 {
-		url = fmt.Sprintf("/%s/%s/_validate/query?q=%s&%s&explain=%s", index, _type, query, api.Pretty(pretty), explain)
-	} else {
-		url = fmt.Sprintf("/%s/_validate/query?q=%s&%s&explain=%s", index, query, api.Pretty(pretty), explain)
+		return fmt.Sprintf("/%s/%s/_validate/query?%s", index, _type, args.Encode())
 	}
-	body, err := api.DoCommand("GET", url, nil)
+	return fmt.Sprintf("/%s/_validate/query?%s", index, args.Encode())
+}
+
+func doValidate(url string, query interface{}) (Validation, error) {
+	var retval Validation
+	body, err := api.DoCommand("GET", url, query)
 	if err != nil {
 		return retval, err
 	}
@@ -27,7 +55,6 @@ func Validate(pretty bool, index string, _type string, query string, explain boo
 			return retval, jsonErr
 		}
 	}
-	fmt.Println(body)
 	return retval, err
 }
 
@@ -37,8 +64,21 @@ type Validation struct {
 	Explainations []Explaination `json:"explanations,omitempty"`
 }
 
+// The per index explanation of a validation, only returned when explain is requested
 type Explaination struct {
-	Index string `json:"index"`
-	Valid bool   `json:"valid"`
-	Error string `json:"error"`
+	Index       string `json:"index"`
+	Valid       bool   `json:"valid"`
+	Error       string `json:"error,omitempty"`
+	Explanation string `json:"explanation,omitempty"`
+}
+
+// The errors of all invalid indices, empty if the query was valid
+func (v *Validation) Errors() []string {
+	errs := make([]string, 0)
+	for _, e := range v.Explainations {
+		if !e.Valid && len(e.Error) > 0 {
+			errs = append(errs, fmt.Sprintf("[%s] %s", e.Index, e.Error))
+		}
+	}
+	return errs
 }
@@ -0,0 +1,31 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	var method, url, body string
+	defer fakeServer(func(w http.ResponseWriter, r *http.Request) {
+		by, _ := ioutil.ReadAll(r.Body)
+		method, url, body = r.Method, r.URL.RequestURI(), string(by)
+		fmt.Fprint(w, `{"valid":true,"_shards":{"total":1,"successful":1,"failed":0},
+			"explanations":[{"index":"github","valid":true,"explanation":"+actor:a* +type:pushevent"}]}`)
+	})()
+
+	v, err := Validate(true, "github", "PushEvent", `actor:a* AND type:"Push Event"&x=1`, true)
+	Assert(err == nil, t, "Should not have error %v", err)
+	Assert(method == "GET" && body == "", t, "Should have no body %s %s", method, body)
+	Assert(url == "/github/PushEvent/_validate/query?explain=true&pretty=1&q=actor%3Aa%2A+AND+type%3A%22Push+Event%22%26x%3D1", t, "Wrong url %s", url)
+	Assert(v.Valid && len(v.Explainations) == 1 && v.Explainations[0].Explanation == "+actor:a* +type:pushevent", t, "Wrong validation %v", v)
+	Assert(len(v.Errors()) == 0, t, "Should not have errors %v", v.Errors())
+
+	qry := map[string]interface{}{"term": map[string]string{"actor": "kimchy"}}
+	_, err = ValidateQuery(false, "github", "", qry, false)
+	Assert(err == nil, t, "Should not have error %v", err)
+	Assert(url == "/github/_validate/query?", t, "Wrong url %s", url)
+	Assert(body == `{"term":{"actor":"kimchy"}}`, t, "Wrong body %s", body)
+}
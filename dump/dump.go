@@ -0,0 +1,359 @@
+// Dump an index into a directory of gzip compressed, newline delimited json files, and
+// restore it again.   Meant for small indices: fixtures, migrations, disaster drills.
+//
+// A dump directory holds:
+//
+//    index.ndjson.gz        the settings, then the mappings of the index, one json object per line
+//    docs-00000.ndjson.gz   the documents, one json object per line (see Doc), ChunkDocs per file
+//    manifest.json          the completed docs files, used to resume an interrupted dump
+//    restore-<index>.json   the docs files restored so far, used to resume an interrupted restore
+package dump
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/mattbaird/elastigo/indices"
+	"github.com/mattbaird/elastigo/search"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	metaFile     = "index.ndjson.gz"
+	manifestFile = "manifest.json"
+)
+
+// A document in a dump
+type Doc struct {
+	Type    string          `json:"_type"`
+	Id      string          `json:"_id"`
+	Routing string          `json:"_routing,omitempty"`
+	Parent  string          `json:"_parent,omitempty"`
+	Source  json.RawMessage `json:"_source"`
+}
+
+// Running counts of a dump or restore, Skipped are documents (dump) or files (restore)
+// already done by a previous, interrupted, run
+type Stats struct {
+	Docs    int
+	Files   int
+	Skipped int
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("<Dump docs=%d files=%d skipped=%d />", s.Docs, s.Files, s.Skipped)
+}
+
+// The completed docs files of a dump
+type manifest struct {
+	Index string   `json:"index"`
+	Files []string `json:"files"`
+	Docs  int      `json:"docs"`
+	Done  bool     `json:"done"`
+}
+
+// A Dumper writes an index into a dump directory.   If the directory holds an
+// unfinished dump of the same index it is resumed, the documents in its completed
+// files are not written again.
+//
+//    d := NewDumper("github", "/tmp/github-dump")
+//    d.Progress = func(stats Stats) { log.Println(stats) }
+//    stats, err := d.Run(ctx)
+type Dumper struct {
+	// Optional query to select the documents, all if nil
+	Query *search.QueryDsl
+	// Number of documents per docs file, defaults to 10000
+	ChunkDocs int
+	// Number of docs per shard per scroll page, defaults to 100
+	PageSize int
+	// Keep alive of the scroll between pages, defaults to "5m"
+	KeepAlive string
+	// Optional func called after each docs file is written, with the counts so far
+	Progress func(stats Stats)
+
+	index string
+	dir   string
+}
+
+func NewDumper(index, dir string) *Dumper {
+	return &Dumper{index: index, dir: dir, ChunkDocs: 10000, PageSize: 100, KeepAlive: "5m"}
+}
+
+// Run the dump, blocking until done, the context is cancelled, or an error
+func (d *Dumper) Run(ctx context.Context) (Stats, error) {
+	var stats Stats
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return stats, err
+	}
+	m, err := readManifest(d.dir)
+	if err != nil {
+		return stats, err
+	}
+	if m.Index != d.index {
+		m = &manifest{Index: d.index, Files: make([]string, 0)}
+	}
+	if m.Done {
+		stats.Skipped, stats.Files = m.Docs, len(m.Files)
+		return stats, nil
+	}
+	if err := d.writeMeta(); err != nil {
+		return stats, err
+	}
+
+	// the ids already in completed files, any other files are from a dump that died
+	// in the middle of writing so are dropped
+	seen, err := readIds(d.dir, m.Files)
+	if err != nil {
+		return stats, err
+	}
+	if err := removeStrays(d.dir, m.Files); err != nil {
+		return stats, err
+	}
+	stats.Files = len(m.Files)
+
+	qry := search.Search(d.index).Scan().Size(strconv.Itoa(positive(d.PageSize, 100))).Fields("_source", "_routing", "_parent")
+	if d.Query != nil {
+		qry.Query(d.Query)
+	}
+	scroller := search.NewScroller(ctx, qry, d.KeepAlive)
+	defer scroller.Close()
+
+	var w *docWriter
+	for scroller.Next() {
+		hit := scroller.Hit()
+		if seen[hit.Type+"/"+hit.Id] {
+			stats.Skipped++
+			continue
+		}
+		if w == nil {
+			if w, err = newDocWriter(d.dir, len(m.Files)); err != nil {
+				return stats, err
+			}
+		}
+		doc := Doc{Type: hit.Type, Id: hit.Id, Source: hit.Source,
+			Routing: hit.StringField("_routing"), Parent: hit.StringField("_parent")}
+		if err = w.Write(&doc); err != nil {
+			w.Abort()
+			return stats, err
+		}
+		stats.Docs++
+		if w.docs >= positive(d.ChunkDocs, 10000) {
+			if err = d.complete(m, w, &stats); err != nil {
+				return stats, err
+			}
+			w = nil
+		}
+	}
+	// a cancelled dump still keeps the whole documents written so far
+	if w != nil {
+		if err = d.complete(m, w, &stats); err != nil {
+			return stats, err
+		}
+	}
+	if err = scroller.Err(); err != nil {
+		return stats, err
+	}
+	m.Done = true
+	return stats, m.write(d.dir)
+}
+
+// close a docs file and record it in the manifest
+func (d *Dumper) complete(m *manifest, w *docWriter, stats *Stats) error {
+	if err := w.Close(); err != nil {
+		return err
+	}
+	m.Files = append(m.Files, w.name)
+	m.Docs += w.docs
+	stats.Files++
+	if err := m.write(d.dir); err != nil {
+		return err
+	}
+	if d.Progress != nil {
+		d.Progress(*stats)
+	}
+	return nil
+}
+
+// write the settings and mappings lines
+func (d *Dumper) writeMeta() error {
+	settings, err := indices.GetSettings(d.index)
+	if err != nil {
+		return err
+	}
+	mappings, err := indices.GetMapping(d.index)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(d.dir, metaFile))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	enc := json.NewEncoder(gz)
+	if err = enc.Encode(map[string]interface{}{"settings": settings[d.index].Settings}); err != nil {
+		return err
+	}
+	if err = enc.Encode(map[string]interface{}{"mappings": mappings[d.index]}); err != nil {
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// A gzip ndjson docs file being written, it only gets its final name once closed
+type docWriter struct {
+	name string
+	path string
+	f    *os.File
+	gz   *gzip.Writer
+	enc  *json.Encoder
+	docs int
+}
+
+func newDocWriter(dir string, n int) (*docWriter, error) {
+	name := fmt.Sprintf("docs-%05d.ndjson.gz", n)
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(f)
+	return &docWriter{name: name, path: path, f: f, gz: gz, enc: json.NewEncoder(gz)}, nil
+}
+
+func (w *docWriter) Write(doc *Doc) error {
+	w.docs++
+	return w.enc.Encode(doc)
+}
+
+func (w *docWriter) Close() error {
+	if err := w.gz.Close(); err != nil {
+		w.f.Close()
+		return err
+	}
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(w.path+".tmp", w.path)
+}
+
+func (w *docWriter) Abort() {
+	w.f.Close()
+	os.Remove(w.path + ".tmp")
+}
+
+// Read each line of a gzip ndjson file
+func readLines(path string, line func([]byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	r := bufio.NewReader(gz)
+	for {
+		by, err := r.ReadBytes('\n')
+		if len(by) > 0 {
+			if lineErr := line(by); lineErr != nil {
+				return lineErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func readIds(dir string, files []string) (map[string]bool, error) {
+	seen := make(map[string]bool)
+	for _, name := range files {
+		err := readLines(filepath.Join(dir, name), func(line []byte) error {
+			var doc Doc
+			if err := json.Unmarshal(line, &doc); err != nil {
+				return err
+			}
+			seen[doc.Type+"/"+doc.Id] = true
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return seen, nil
+}
+
+// remove docs files that are not in the manifest
+func removeStrays(dir string, files []string) error {
+	keep := make(map[string]bool)
+	for _, name := range files {
+		keep[name] = true
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, "docs-*.ndjson.gz*"))
+	if err != nil {
+		return err
+	}
+	for _, path := range matches {
+		if !keep[filepath.Base(path)] {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// read the manifest, empty if there is none
+func readManifest(dir string) (*manifest, error) {
+	m := &manifest{Files: make([]string, 0)}
+	if err := readJson(filepath.Join(dir, manifestFile), m); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *manifest) write(dir string) error {
+	return writeJson(filepath.Join(dir, manifestFile), m)
+}
+
+func readJson(path string, v interface{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewDecoder(f).Decode(v)
+}
+
+// write json to a temp file and rename it into place, so it is never half written
+func writeJson(path string, v interface{}) error {
+	by, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(path+".tmp", by, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func positive(n, def int) int {
+	if n <= 0 {
+		return def
+	}
+	return n
+}
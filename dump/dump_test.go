@@ -0,0 +1,176 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	. "github.com/araddon/gou"
+	"github.com/mattbaird/elastigo/api"
+	"github.com/mattbaird/elastigo/core"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// a fake elasticsearch with a 3 doc "github" index
+func dumpServer(t *testing.T, created *string) func() {
+	pages := map[string]string{
+		"s1": `{"_scroll_id":"s2","hits":{"total":3,"hits":[
+			{"_index":"github","_type":"user","_id":"1","_source":{"name":"bob"}},
+			{"_index":"github","_type":"user","_id":"2","_source":{"name":"jane"},"fields":{"_routing":"r2"}}]}}`,
+		"s2": `{"_scroll_id":"s3","hits":{"total":3,"hits":[
+			{"_index":"github","_type":"comment","_id":"3","_source":{"text":"hi"},"fields":{"_parent":"1"}}]}}`,
+		"s3": `{"_scroll_id":"s4","hits":{"total":3,"hits":[]}}`,
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch {
+		case r.URL.Path == "/github/_settings":
+			fmt.Fprint(w, `{"github":{"settings":{"index.number_of_shards":"1","index.uuid":"abc"}}}`)
+		case r.URL.Path == "/github/_mapping":
+			fmt.Fprint(w, `{"github":{"user":{"properties":{"name":{"type":"string"}}}}}`)
+		case r.URL.Path == "/github/_search":
+			fmt.Fprint(w, `{"_scroll_id":"s1","hits":{"total":3,"hits":[]}}`)
+		case r.URL.Path == "/_search/scroll" && r.Method == "DELETE":
+			fmt.Fprint(w, `{"ok":true}`)
+		case r.URL.Path == "/_search/scroll":
+			fmt.Fprint(w, pages[string(body)])
+		case r.URL.Path == "/github_copy" && r.Method == "HEAD":
+			if len(*created) == 0 {
+				w.WriteHeader(404)
+			}
+		case r.URL.Path == "/github_copy" && r.Method == "PUT":
+			*created = string(body)
+			fmt.Fprint(w, `{"ok":true}`)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	u, _ := url.Parse(ts.URL)
+	host, port, _ := net.SplitHostPort(u.Host)
+	domain, origPort := api.Domain, api.Port
+	api.Domain, api.Port = host, port
+	return func() {
+		api.Domain, api.Port = domain, origPort
+		ts.Close()
+	}
+}
+
+func TestDumpRestore(t *testing.T) {
+	created := ""
+	defer dumpServer(t, &created)()
+	dir, _ := ioutil.TempDir("", "esdump")
+	defer os.RemoveAll(dir)
+
+	d := NewDumper("github", dir)
+	d.ChunkDocs = 2
+	d.KeepAlive = "1m"
+	progressCt := 0
+	d.Progress = func(stats Stats) {
+		progressCt++
+	}
+	stats, err := d.Run(context.Background())
+	Assert(err == nil, t, "Should not have error %v", err)
+	Assert(stats.Docs == 3 && stats.Files == 2, t, "Should have dumped 3 docs in 2 files %v", stats)
+	Assert(progressCt == 2, t, "Should have reported progress per file %v", progressCt)
+
+	// resume a dump that only completed the first file
+	m, _ := readManifest(dir)
+	m.Files, m.Docs, m.Done = m.Files[:1], 2, false
+	m.write(dir)
+	stats, err = d.Run(context.Background())
+	Assert(err == nil, t, "Should not have error %v", err)
+	Assert(stats.Docs == 1 && stats.Skipped == 2 && stats.Files == 2, t, "Should have resumed %v", stats)
+	files, _ := filepath.Glob(filepath.Join(dir, "docs-*"))
+	Assert(len(files) == 2, t, "Should have 2 docs files %v", files)
+
+	bulk := ""
+	indexor := core.NewBulkIndexor(1)
+	indexor.BulkSendor = func(buf *bytes.Buffer) error {
+		bulk += buf.String()
+		return nil
+	}
+	indexor.Run(make(chan bool))
+
+	r := NewRestorer(dir, "github_copy", indexor)
+	stats, err = r.Run(context.Background())
+	Assert(err == nil, t, "Should not have error %v", err)
+	Assert(stats.Docs == 3 && stats.Files == 2, t, "Should have restored 3 docs %v", stats)
+
+	var body map[string]map[string]interface{}
+	json.Unmarshal([]byte(created), &body)
+	Assert(body["settings"]["index.number_of_shards"] == "1", t, "Should create with settings %v", created)
+	Assert(body["settings"]["index.uuid"] == nil, t, "Should not restore the uuid %v", created)
+	Assert(body["mappings"]["user"] != nil, t, "Should create with mappings %v", created)
+
+	err = indexor.Close(context.Background())
+	Assert(err == nil && strings.Count(bulk, "\n") == 6, t, "Should have sent 3 docs %v %v", bulk, err)
+	Assert(strings.Contains(bulk, `{"index":{"_index":"github_copy","_type":"user","_id":"2","_routing":"r2"}}`), t, "Should keep routing %v", bulk)
+	Assert(strings.Contains(bulk, `{"index":{"_index":"github_copy","_type":"comment","_id":"3","_parent":"1"}}`), t, "Should keep parent %v", bulk)
+
+	// everything is restored, so a second run has nothing to do
+	stats, err = r.Run(context.Background())
+	Assert(err == nil && stats.Docs == 0 && stats.Skipped == 2, t, "Should have resumed %v %v", stats, err)
+}
+
+func TestRestoreFailed(t *testing.T) {
+	created := ""
+	defer dumpServer(t, &created)()
+	dir, _ := ioutil.TempDir("", "esdump")
+	defer os.RemoveAll(dir)
+
+	d := NewDumper("github", dir)
+	d.ChunkDocs = 2
+	_, err := d.Run(context.Background())
+	Assert(err == nil, t, "Should not have error %v", err)
+
+	// the doc of the second file is rejected
+	indexor := core.NewBulkIndexor(1)
+	indexor.BulkSendor = func(buf *bytes.Buffer) error {
+		if strings.Contains(buf.String(), `"_id":"3"`) {
+			return &core.BulkItemsError{Response: core.BulkResponse{Items: []core.BulkItemResult{
+				{Action: "index", Id: "3", Status: 400, Error: "MapperParsingException[failed to parse]"},
+			}}}
+		}
+		return nil
+	}
+	indexor.Run(make(chan bool))
+	r := NewRestorer(dir, "github_copy", indexor)
+	stats, err := r.Run(context.Background())
+	Assert(err != nil && stats.Files == 1, t, "Should have failed on the second file %v %v", stats, err)
+	var state restoreState
+	readJson(filepath.Join(dir, "restore-github_copy.json"), &state)
+	Assert(len(state.Files) == 1, t, "Should only record the restored file %v", state)
+	indexor.Close(context.Background())
+
+	// running again only restores the failed file
+	bulk := ""
+	indexor = core.NewBulkIndexor(1)
+	indexor.BulkSendor = func(buf *bytes.Buffer) error {
+		bulk += buf.String()
+		return nil
+	}
+	indexor.Run(make(chan bool))
+	r = NewRestorer(dir, "github_copy", indexor)
+	stats, err = r.Run(context.Background())
+	Assert(err == nil && stats.Docs == 1 && stats.Skipped == 1, t, "Should have resumed %v %v", stats, err)
+	Assert(strings.Contains(bulk, `"_id":"3"`), t, "Should have sent the failed doc %v", bulk)
+	indexor.Close(context.Background())
+
+	// a cancelled restore records nothing
+	os.Remove(filepath.Join(dir, "restore-github_copy.json"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	indexor = core.NewBulkIndexor(1)
+	indexor.Run(make(chan bool))
+	stats, err = NewRestorer(dir, "github_copy", indexor).Run(ctx)
+	Assert(err == context.Canceled && stats.Files == 0, t, "Should have been cancelled %v %v", stats, err)
+	indexor.Close(context.Background())
+}
@@ -0,0 +1,105 @@
+/**
+* Copyright 2012 Matthew Baird
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+*  Unless required by applicable law or agreed to in writing, software
+*  distributed under the License is distributed on an "AS IS" BASIS,
+*  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*  See the License for the specific language governing permissions and
+*  limitations under the License.
+**/
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/mattbaird/elastigo/api"
+	"github.com/mattbaird/elastigo/core"
+	"github.com/mattbaird/elastigo/dump"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+)
+
+/*
+
+Dump an index to a directory of gzip ndjson files, or restore one.  Re-running an
+interrupted dump or restore resumes it.
+
+usage:
+
+	esdump -host eshost -index github -dir /tmp/github-dump
+	esdump -host eshost -restore -index github_copy -dir /tmp/github-dump
+
+*/
+
+var (
+	eshost    *string = flag.String("host", "localhost", "Elasticsearch Server Host Address")
+	esport    *string = flag.String("port", "9200", "Elasticsearch Server Port")
+	index     *string = flag.String("index", "", "Index to dump, or to restore into (default: the dumped index)")
+	dir       *string = flag.String("dir", "", "Directory of the dump")
+	restore   *bool   = flag.Bool("restore", false, "Restore the dump in dir, rather than dumping")
+	chunkDocs *int    = flag.Int("chunkdocs", 10000, "Documents per dump file")
+	maxConns  *int    = flag.Int("maxconns", 4, "Max concurrent bulk requests when restoring")
+)
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.Ltime | log.Lshortfile)
+	if len(*dir) == 0 || (!*restore && len(*index) == 0) {
+		flag.Usage()
+		os.Exit(1)
+	}
+	api.Domain = *eshost
+	api.Port = *esport
+
+	// stop cleanly on ctrl-c, so we can resume
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		<-sigs
+		log.Println("interrupted, stopping")
+		cancel()
+	}()
+
+	progress := func(stats dump.Stats) {
+		log.Println(stats.String())
+	}
+
+	var stats dump.Stats
+	var err error
+	if *restore {
+		indexor := core.NewBulkIndexorErrors(*maxConns, 10)
+		indexor.Run(make(chan bool))
+		go func() {
+			for errBuf := range indexor.ErrorChannel {
+				log.Println("bulk error: ", errBuf.Err)
+			}
+		}()
+		r := dump.NewRestorer(*dir, *index, indexor)
+		r.Progress = progress
+		stats, err = r.Run(ctx)
+		// wait for the docs handed to the indexor to be sent, even if interrupted
+		closeCtx, closeCancel := context.WithTimeout(context.Background(), time.Minute)
+		if closeErr := indexor.Close(closeCtx); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		closeCancel()
+	} else {
+		d := dump.NewDumper(*index, *dir)
+		d.ChunkDocs = *chunkDocs
+		d.Progress = progress
+		stats, err = d.Run(ctx)
+	}
+	if err != nil {
+		log.Fatalf("%v after %v", err, stats.String())
+	}
+	log.Println("done ", stats.String())
+}
@@ -0,0 +1,160 @@
+package dump
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mattbaird/elastigo/core"
+	"github.com/mattbaird/elastigo/indices"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Settings that belong to the index they were read from, they can not be restored
+var restoreSkipSettings = []string{"index.uuid", "index.version.created"}
+
+// The docs files restored so far
+type restoreState struct {
+	Files []string `json:"files"`
+}
+
+// A Restorer loads a dump directory into an index, creating the index with the dumped
+// settings and mappings if it does not exist and bulk loading the documents through a
+// BulkIndexor, which must already be running and only used by the Restorer.   A docs
+// file is recorded as restored once all its docs have been sent without failing, so
+// if the restore is interrupted, or docs fail, running it again resumes with the
+// first file not fully restored.   Documents that fail in the BulkIndexor are
+// reported through its ErrorChannel, which must be read.
+//
+//    indexor := core.NewBulkIndexorErrors(10, 60)
+//    indexor.Run(done)
+//    r := NewRestorer("/tmp/github-dump", "github_copy", indexor)
+//    stats, err := r.Run(ctx)
+type Restorer struct {
+	// Optional func called after each docs file is loaded, with the counts so far
+	Progress func(stats Stats)
+
+	dir     string
+	index   string
+	indexor *core.BulkIndexor
+}
+
+// Restore the dump in @dir into @index, "" to use the name of the dumped index
+func NewRestorer(dir, index string, indexor *core.BulkIndexor) *Restorer {
+	return &Restorer{dir: dir, index: index, indexor: indexor}
+}
+
+// Run the restore, blocking until done, the context is cancelled, or an error
+func (r *Restorer) Run(ctx context.Context) (Stats, error) {
+	var stats Stats
+	m, err := readManifest(r.dir)
+	if err != nil {
+		return stats, err
+	}
+	if !m.Done {
+		return stats, errors.New("dump in " + r.dir + " is not complete")
+	}
+	index := r.index
+	if len(index) == 0 {
+		index = m.Index
+	}
+	if err = r.createIndex(index); err != nil {
+		return stats, err
+	}
+
+	statePath := filepath.Join(r.dir, "restore-"+index+".json")
+	state := restoreState{Files: make([]string, 0)}
+	if err = readJson(statePath, &state); err != nil && !os.IsNotExist(err) {
+		return stats, err
+	}
+	restored := make(map[string]bool)
+	for _, name := range state.Files {
+		restored[name] = true
+	}
+
+	for _, name := range m.Files {
+		if restored[name] {
+			stats.Skipped++
+			continue
+		}
+		if err = ctx.Err(); err != nil {
+			return stats, err
+		}
+		failedBefore := r.indexor.Stats().Errors
+		err = readLines(filepath.Join(r.dir, name), func(line []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			var doc Doc
+			if err := json.Unmarshal(line, &doc); err != nil {
+				return err
+			}
+			stats.Docs++
+			return r.indexor.IndexRouted(index, doc.Type, doc.Id, doc.Routing, doc.Parent, nil, []byte(doc.Source))
+		})
+		if err != nil {
+			return stats, err
+		}
+		if err = r.delivered(ctx); err != nil {
+			return stats, err
+		}
+		bulkStats := r.indexor.Stats()
+		if failed := bulkStats.Errors - failedBefore; failed > 0 {
+			return stats, fmt.Errorf("%d docs of %s failed to restore", failed, name)
+		}
+		if bulkStats.Spooled > 0 {
+			return stats, fmt.Errorf("docs of %s are waiting in the bulk spool", name)
+		}
+		stats.Files++
+		state.Files = append(state.Files, name)
+		if err = writeJson(statePath, &state); err != nil {
+			return stats, err
+		}
+		if r.Progress != nil {
+			r.Progress(stats)
+		}
+	}
+	return stats, nil
+}
+
+// Wait until the docs handed to the indexor have been sent, or failed, flushing the
+// partly filled buffers as the docs reach them
+func (r *Restorer) delivered(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		r.indexor.Flush()
+		if r.indexor.Stats().InFlightBytes == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// create the index from the dumped settings and mappings, unless it exists
+func (r *Restorer) createIndex(index string) error {
+	exists, err := indices.IndicesExists(index)
+	if err != nil || exists {
+		return err
+	}
+	body := make(map[string]interface{})
+	err = readLines(filepath.Join(r.dir, metaFile), func(line []byte) error {
+		return json.Unmarshal(line, &body)
+	})
+	if err != nil {
+		return err
+	}
+	if settings, ok := body["settings"].(map[string]interface{}); ok {
+		for _, name := range restoreSkipSettings {
+			delete(settings, name)
+		}
+	}
+	_, err = indices.CreateIndex(index, body)
+	return err
+}
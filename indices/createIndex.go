@@ -1 +1,30 @@
-package indices
\ No newline at end of file
+package indices
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mattbaird/elastigo/api"
+)
+
+// The create index API allows to instantiate an index, with optional settings and
+// mappings (nil for defaults) in the body:
+//
+//   {"settings": {"number_of_shards": 3}, "mappings": {"type1": {"properties": {...}}}}
+//
+// http://www.elasticsearch.org/guide/reference/api/admin-indices-create-index.html
+func CreateIndex(index string, body interface{}) (api.BaseResponse, error) {
+	var retval api.BaseResponse
+	url := fmt.Sprintf("/%s", index)
+	resp, err := api.DoCommand("PUT", url, body)
+	if err != nil {
+		return retval, err
+	}
+	if err == nil {
+		// marshall into json
+		jsonErr := json.Unmarshal(resp, &retval)
+		if jsonErr != nil {
+			return retval, jsonErr
+		}
+	}
+	return retval, err
+}
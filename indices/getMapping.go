@@ -0,0 +1,33 @@
+package indices
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mattbaird/elastigo/api"
+	"strings"
+)
+
+// Get the mappings of an index, for the given types (all if none), returned as
+// index name => type name => raw mapping json
+// http://www.elasticsearch.org/guide/reference/api/admin-indices-get-mapping.html
+func GetMapping(index string, types ...string) (map[string]map[string]json.RawMessage, error) {
+	var url string
+	retval := make(map[string]map[string]json.RawMessage)
+	if len(types) > 0 {
+		url = fmt.Sprintf("/%s/%s/_mapping", index, strings.Join(types, ","))
+	} else {
+		url = fmt.Sprintf("/%s/_mapping", index)
+	}
+	body, err := api.DoCommand("GET", url, nil)
+	if err != nil {
+		return retval, err
+	}
+	if err == nil {
+		// marshall into json
+		jsonErr := json.Unmarshal(body, &retval)
+		if jsonErr != nil {
+			return retval, jsonErr
+		}
+	}
+	return retval, err
+}
@@ -1 +1,36 @@
-package indices
\ No newline at end of file
+package indices
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mattbaird/elastigo/api"
+	"strings"
+)
+
+type IndexSettings struct {
+	Settings map[string]interface{} `json:"settings"`
+}
+
+// Get the settings of one or more (all if none) indices, keyed by index name
+// http://www.elasticsearch.org/guide/reference/api/admin-indices-get-settings.html
+func GetSettings(indices ...string) (map[string]IndexSettings, error) {
+	var url string
+	retval := make(map[string]IndexSettings)
+	if len(indices) > 0 {
+		url = fmt.Sprintf("/%s/_settings", strings.Join(indices, ","))
+	} else {
+		url = "/_settings"
+	}
+	body, err := api.DoCommand("GET", url, nil)
+	if err != nil {
+		return retval, err
+	}
+	if err == nil {
+		// marshall into json
+		jsonErr := json.Unmarshal(body, &retval)
+		if jsonErr != nil {
+			return retval, jsonErr
+		}
+	}
+	return retval, err
+}
@@ -1 +1,20 @@
 package indices
+
+import (
+	"github.com/mattbaird/elastigo/api"
+	"strings"
+)
+
+// Check whether all of the given indices exist, using HEAD
+// http://www.elasticsearch.org/guide/reference/api/admin-indices-indices-exists.html
+func IndicesExists(indices ...string) (bool, error) {
+	req, err := api.ElasticSearchRequest("HEAD", "/"+strings.Join(indices, ","))
+	if err != nil {
+		return false, err
+	}
+	httpStatusCode, _, err := req.Do(nil)
+	if err != nil {
+		return false, err
+	}
+	return httpStatusCode == 200, nil
+}
@@ -34,8 +34,9 @@ func Facet() *FacetDsl {
 }
 
 type FacetDsl struct {
-	size  string
-	Terms map[string]Term `json:"terms,omitempty"`
+	size   string
+	filter *FilterWrap
+	Terms  map[string]Term `json:"terms,omitempty"`
 }
 
 func (m *FacetDsl) Size(size string) *FacetDsl {
@@ -43,11 +44,23 @@ func (m *FacetDsl) Size(size string) *FacetDsl {
 	return m
 }
 
+// Only count the docs matching these filter clauses, in the same form as
+// SearchDsl.Filter, queries included
+//
+//    Facet().Fields("actor").Filter(Query().Bool(Bool().Must(Query().Term("type", "PushEvent"))))
+func (m *FacetDsl) Filter(fl ...interface{}) *FacetDsl {
+	if m.filter == nil {
+		m.filter = NewFilterWrap()
+	}
+	m.filter.addFilters(fl)
+	return m
+}
+
 func (m *FacetDsl) Regex(field, match string) *FacetDsl {
 	if len(m.Terms) == 0 {
 		m.Terms = make(map[string]Term)
 	}
-	m.Terms[field] = Term{&Terms{Fields: []string{field}, Regex: match}}
+	m.Terms[field] = Term{Terms: &Terms{Fields: []string{field}, Regex: match}}
 	return m
 }
 
@@ -58,14 +71,16 @@ func (m *FacetDsl) Fields(fields ...string) *FacetDsl {
 	if len(m.Terms) == 0 {
 		m.Terms = make(map[string]Term)
 	}
-	m.Terms[fields[0]] = Term{&Terms{Fields: fields}}
+	m.Terms[fields[0]] = Term{Terms: &Terms{Fields: fields}}
 	return m
 }
 
 func (m *FacetDsl) MarshalJSON() ([]byte, error) {
 	// Custom marshall
-	for _, t := range m.Terms {
+	for name, t := range m.Terms {
 		t.Terms.Size = m.size
+		t.FacetFilter = m.filter
+		m.Terms[name] = t
 	}
 	return json.Marshal(&m.Terms)
 }
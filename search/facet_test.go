@@ -1,7 +1,7 @@
 package search
 
 import (
-	//"encoding/json"
+	"encoding/json"
 	. "github.com/araddon/gou"
 	"testing"
 )
@@ -21,3 +21,12 @@ func TestFacetRegex(t *testing.T) {
 	facets := fh.Helpers("/repository.name/terms")
 	Assert(len(facets) == 8, t, "Should have 8? docs %v", len(facets))
 }
+
+func TestFacetFilter(t *testing.T) {
+	// filters and queries, as in a search
+	b, err := json.Marshal(Facet().Fields("actor").Size("5").Filter(Filter().Exists("repository.language"), Query().Search("add")))
+	Assert(err == nil, t, "should not have error %v", err)
+	expected := `{"actor":{"terms":{"field":"actor","size":"5"},` +
+		`"facet_filter":{"and":[{"exists":{"field":"repository.language"}},{"query":{"query_string":{"query":"add"}}}]}}}`
+	Assert(string(b) == expected, t, "Wrong facet filter %s", string(b))
+}
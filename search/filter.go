@@ -3,6 +3,8 @@ package search
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
+	"time"
 
 	. "github.com/araddon/gou"
 )
@@ -21,70 +23,248 @@ type FilterClause interface {
 }
 
 // A wrapper to allow for custom serialization
-//     A filter is a nested set of operators (not,and,or) and the
-//     filter clauses that are contained in them
+//     The filters of a search, and-ed.   They are added with optional LogicClause
+//     strings, which apply to the filters after them in the same call only.
 type FilterWrap struct {
-	// The complete map of logic operators to their nested filters
-	filtermap map[LogicClause][]interface{}
-	// The current LogicClause, can be none (which = "and")
-	curClause LogicClause
+	filters []interface{}
 }
 
 func NewFilterWrap() *FilterWrap {
-	return &FilterWrap{curClause: "and"}
+	return &FilterWrap{}
 }
 
 func (f *FilterWrap) String() string {
-	return fmt.Sprintf(`fopv: %d:%v`, len(f.filtermap), f.filtermap)
+	return fmt.Sprintf(`fopv: %d:%v`, len(f.filters), f.filters)
 }
 
-// logic associated with adding filters.   
+// logic associated with adding filters.   Each call starts with "and", the filters
+// after an "or" become one Or, after a "not" each is negated.
 func (f *FilterWrap) addFilters(filterList []interface{}) {
-	// Default clause is "and"
-	if len(f.curClause) == 0 {
-		f.curClause = LogicClause("and")
-	}
-	if len(f.filtermap) == 0 {
-		f.filtermap = make(map[LogicClause][]interface{})
-	}
+	clause := ANDCLAUSE
+	var or *FilterNode
 	for _, filterClause := range filterList {
-		switch clauseOp := filterClause.(type) {
-		case LogicClause, string:
-			f.curClause = LogicClause(filterClause.(string))
-		case *QueryDsl:
-			if len(f.filtermap[f.curClause]) == 0 {
-				f.filtermap[f.curClause] = make([]interface{}, 0)
-			}
-			f.filtermap[f.curClause] = append(f.filtermap[f.curClause], map[string]*QueryDsl{"query": clauseOp})
-		case *FilterOp:
-			if len(f.filtermap[f.curClause]) == 0 {
-				f.filtermap[f.curClause] = make([]interface{}, 0)
+		switch op := filterClause.(type) {
+		case LogicClause:
+			clause, or = op, nil
+		case string:
+			clause, or = LogicClause(op), nil
+		default:
+			fc, ok := filterValue(filterClause)
+			if !ok {
+				continue
 			}
-			if clauseOp.not {
-				m := map[string]*FilterOp{"not": clauseOp}
-				f.filtermap[f.curClause] = append(f.filtermap[f.curClause], m)
-			} else {
-				f.filtermap[f.curClause] = append(f.filtermap[f.curClause], clauseOp)
+			switch clause {
+			case "or":
+				if or == nil {
+					or = Or()
+					f.filters = append(f.filters, or)
+				}
+				or.filters = append(or.filters, fc)
+			case "not":
+				f.filters = append(f.filters, Not(filterClause))
+			default:
+				f.filters = append(f.filters, fc)
 			}
-		default:
-			Logf(ERROR, "Unkown Filter Clause? %v", clauseOp)
 		}
 	}
 }
 
 // Custom marshalling to support the query dsl 
 func (f *FilterWrap) MarshalJSON() ([]byte, error) {
+	// a single filter does not need the "and"
+	switch len(f.filters) {
+	case 0:
+		return json.Marshal(Filter())
+	case 1:
+		return json.Marshal(f.filters[0])
+	}
+	// the filters are already converted by addFilters
+	return json.Marshal(&FilterNode{op: ANDCLAUSE, filters: f.filters})
+}
+
+// The filter to send for a clause of a filter tree, a query is wrapped in a query
+// filter
+func filterValue(filterClause interface{}) (interface{}, bool) {
+	switch fc := filterClause.(type) {
+	case *QueryDsl:
+		return map[string]*QueryDsl{"query": fc}, true
+	case *FilterOp, *FilterNode, *BoolFilterNode:
+		return fc, true
+	}
+	Logf(ERROR, "Unkown Filter Clause? %v", filterClause)
+	return nil, false
+}
+
+/*
+	"filter": {
+	  "and": [
+	    {"term": {"actor_attributes.location": "portland"}},
+	    {"or": {
+	      "filters": [
+	        {"range": {"repository.forks": {"gte": 100}}},
+	        {"not": {"filter": {"exists": {"field": "repository.language"}}}}
+	      ],
+	      "_cache": true,
+	      "_name": "popular"
+	    }}
+	  ]
+	}
+*/
+
+// A node of a filter tree, of filters which may be FilterOps, queries or other
+// nodes, nested as deep as needed:
+//
+//    Search("github").Filter(
+//        And(
+//            Filter().Terms("actor_attributes.location", "portland"),
+//            Or(
+//                Range().Field("repository.forks").Gte(100),
+//                Not(Filter().Exists("repository.language")),
+//            ).Cache(true).Name("popular"),
+//        ),
+//    )
+type FilterNode struct {
+	op      LogicClause
+	filters []interface{}
+	cache   *bool
+	name    string
+}
+
+// All the filters must match
+func And(filters ...interface{}) *FilterNode {
+	return newFilterNode(ANDCLAUSE, filters)
+}
+
+// Any of the filters must match
+func Or(filters ...interface{}) *FilterNode {
+	return newFilterNode("or", filters)
+}
+
+// The filters must not match, several are and-ed: Not(a, b) is not (a and b)
+func Not(filters ...interface{}) *FilterNode {
+	return newFilterNode("not", filters)
+}
+
+func newFilterNode(op LogicClause, filters []interface{}) *FilterNode {
+	return (&FilterNode{op: op}).Add(filters...)
+}
+
+// Add filters to the node
+func (n *FilterNode) Add(filters ...interface{}) *FilterNode {
+	n.filters = appendFilters(n.filters, filters)
+	return n
+}
+
+// Cache the result of the filter, or not, for filters cached by default
+func (n *FilterNode) Cache(cache bool) *FilterNode {
+	n.cache = &cache
+	return n
+}
+
+// Name the filter, the hits list the names of the filters they matched
+func (n *FilterNode) Name(name string) *FilterNode {
+	n.name = name
+	return n
+}
+
+func (n *FilterNode) MarshalJSON() ([]byte, error) {
+	if n.op == "not" {
+		var filter interface{} = And(n.filters...)
+		if len(n.filters) == 1 {
+			filter = n.filters[0]
+		}
+		not := map[string]interface{}{"filter": filter}
+		setFilterOptions(not, n.cache, n.name)
+		return json.Marshal(map[string]interface{}{"not": not})
+	}
+	filters := n.filters
+	if filters == nil {
+		filters = make([]interface{}, 0)
+	}
+	if n.cache == nil && n.name == "" {
+		return json.Marshal(map[LogicClause][]interface{}{n.op: filters})
+	}
+	opts := map[string]interface{}{"filters": filters}
+	setFilterOptions(opts, n.cache, n.name)
+	return json.Marshal(map[LogicClause]interface{}{n.op: opts})
+}
+
+// A bool filter, of filters which may be FilterOps, queries or filter tree nodes
+//
+//    BoolFilter().
+//        Must(Filter().Terms("type", "PushEvent")).
+//        Should(Filter().Terms("repository.language", "go"), Filter().Terms("repository.language", "python")).
+//        MustNot(Filter().Exists("repository.fork"))
+func BoolFilter() *BoolFilterNode {
+	return &BoolFilterNode{}
+}
+
+type BoolFilterNode struct {
+	must    []interface{}
+	should  []interface{}
+	mustNot []interface{}
+	cache   *bool
+	name    string
+}
+
+func (b *BoolFilterNode) Must(filters ...interface{}) *BoolFilterNode {
+	b.must = appendFilters(b.must, filters)
+	return b
+}
 
-	var root interface{}
-	_, hasAnd := f.filtermap[ANDCLAUSE]
+func (b *BoolFilterNode) Should(filters ...interface{}) *BoolFilterNode {
+	b.should = appendFilters(b.should, filters)
+	return b
+}
 
-	// If we only have one clause and its an and we don't need to
-	// send as that is default for elasticsearch
-	if len(f.filtermap) == 1 && hasAnd && len(f.filtermap[ANDCLAUSE]) == 1 {
-		root = f.filtermap[ANDCLAUSE]
-		return json.Marshal(root)
+func (b *BoolFilterNode) MustNot(filters ...interface{}) *BoolFilterNode {
+	b.mustNot = appendFilters(b.mustNot, filters)
+	return b
+}
+
+// See FilterNode.Cache
+func (b *BoolFilterNode) Cache(cache bool) *BoolFilterNode {
+	b.cache = &cache
+	return b
+}
+
+// See FilterNode.Name
+func (b *BoolFilterNode) Name(name string) *BoolFilterNode {
+	b.name = name
+	return b
+}
+
+func (b *BoolFilterNode) MarshalJSON() ([]byte, error) {
+	opts := make(map[string]interface{})
+	if len(b.must) > 0 {
+		opts["must"] = b.must
+	}
+	if len(b.should) > 0 {
+		opts["should"] = b.should
+	}
+	if len(b.mustNot) > 0 {
+		opts["must_not"] = b.mustNot
+	}
+	setFilterOptions(opts, b.cache, b.name)
+	return json.Marshal(map[string]interface{}{"bool": opts})
+}
+
+func appendFilters(to []interface{}, filters []interface{}) []interface{} {
+	for _, filterClause := range filters {
+		if fc, ok := filterValue(filterClause); ok {
+			to = append(to, fc)
+		}
+	}
+	return to
+}
+
+func setFilterOptions(opts map[string]interface{}, cache *bool, name string) {
+	if cache != nil {
+		opts["_cache"] = *cache
+	}
+	if name != "" {
+		opts["_name"] = name
 	}
-	return json.Marshal(f.filtermap)
 }
 
 /*
@@ -157,25 +337,40 @@ func Filter() *FilterOp {
 
 type FilterOp struct {
 	curField   string
-	not        bool                         // The not operator
-	TermsMap   map[string][]interface{}     `json:"terms,omitempty"`
-	Range      map[string]map[string]string `json:"range,omitempty"`
-	Exist      map[string]string            `json:"exists,omitempty"`
-	MissingVal map[string]string            `json:"missing,omitempty"`
+	not        bool                              // The not operator
+	added      []*FilterOp                       // Filters combined with this one by Add
+	cache      *bool                             // _cache option
+	name       string                            // _name option
+	TermsMap   map[string][]interface{}          `json:"terms,omitempty"`
+	Range      map[string]map[string]interface{} `json:"range,omitempty"`
+	Exist      map[string]string                 `json:"exists,omitempty"`
+	MissingVal map[string]string                 `json:"missing,omitempty"`
+	PrefixVal  map[string]string                 `json:"prefix,omitempty"`
+	RegexpVal  map[string]map[string]interface{} `json:"regexp,omitempty"`
+	JoinVal    []*JoinQuery                      `json:"-"`
+	GeoVal     []map[string]interface{}          `json:"-"`
 }
 
-// A range is a special type of Filter operation
+// A range is a special type of Filter operation, of one or more fields, which must
+// all be in range
 //
-//    Range().Exists("repository.name")
+//    Range().Field("repository.forks").Gte(100).Lt(500)
+//
+//    Range().Field("created_at").Gte(time.Now().Add(-time.Hour)).Field("repository.size").Gt(0)
+//
+// The same ranges can be used as a query, see QueryDsl.RangeQuery
 func Range() *FilterOp {
-	return &FilterOp{Range: make(map[string]map[string]string)}
+	return &FilterOp{Range: make(map[string]map[string]interface{})}
 }
 
+// The field the following range options apply to
 func (f *FilterOp) Field(fld string) *FilterOp {
 	f.curField = fld
+	if f.Range == nil {
+		f.Range = make(map[string]map[string]interface{})
+	}
 	if _, ok := f.Range[fld]; !ok {
-		m := make(map[string]string)
-		f.Range[fld] = m
+		f.Range[fld] = make(map[string]interface{})
 	}
 	return f
 }
@@ -198,13 +393,69 @@ func (f *FilterOp) Terms(field string, values ...interface{}) *FilterOp {
 	return f
 }
 func (f *FilterOp) From(from string) *FilterOp {
-	f.Range[f.curField]["from"] = from
-	return f
+	return f.rangeOpt("from", from)
 }
 func (f *FilterOp) To(to string) *FilterOp {
-	f.Range[f.curField]["to"] = to
+	return f.rangeOpt("to", to)
+}
+
+// Range bounds, the @value may be a number, a string or a time.Time, which is sent
+// as ISO 8601 with milliseconds
+//
+//    Range().Field("age").Gte(18).Lt(65)
+func (f *FilterOp) Gt(value interface{}) *FilterOp {
+	return f.rangeOpt("gt", rangeValue(value))
+}
+func (f *FilterOp) Gte(value interface{}) *FilterOp {
+	return f.rangeOpt("gte", rangeValue(value))
+}
+func (f *FilterOp) Lt(value interface{}) *FilterOp {
+	return f.rangeOpt("lt", rangeValue(value))
+}
+func (f *FilterOp) Lte(value interface{}) *FilterOp {
+	return f.rangeOpt("lte", rangeValue(value))
+}
+
+// Whether From includes the value, default true
+func (f *FilterOp) IncludeLower(include bool) *FilterOp {
+	return f.rangeOpt("include_lower", include)
+}
+
+// Whether To includes the value, default true
+func (f *FilterOp) IncludeUpper(include bool) *FilterOp {
+	return f.rangeOpt("include_upper", include)
+}
+
+// The date format of string bounds, such as "yyyy-MM-dd".   A time.Time bound must
+// then be accepted too: "yyyy-MM-dd||dateOptionalTime"
+func (f *FilterOp) Format(format string) *FilterOp {
+	return f.rangeOpt("format", format)
+}
+
+// The time zone of dates without one, "+01:00" or "Europe/Paris"
+func (f *FilterOp) TimeZone(zone string) *FilterOp {
+	return f.rangeOpt("time_zone", zone)
+}
+
+func (f *FilterOp) rangeOpt(name string, value interface{}) *FilterOp {
+	f.Field(f.curField)
+	f.Range[f.curField][name] = value
 	return f
 }
+
+// the layout of time.Time range bounds, elasticsearch's default dateOptionalTime
+const rangeTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+func rangeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format(rangeTimeLayout)
+	case *time.Time:
+		return v.Format(rangeTimeLayout)
+	}
+	return value
+}
+
 func (f *FilterOp) Exists(name string) *FilterOp {
 	f.Exist = map[string]string{"field": name}
 	return f
@@ -213,36 +464,162 @@ func (f *FilterOp) Missing(name string) *FilterOp {
 	f.MissingVal = map[string]string{"field": name}
 	return f
 }
+// Docs with a term of the field starting with @prefix
+//
+//    Filter().Prefix("sku", "AB-")
+func (f *FilterOp) Prefix(field, prefix string) *FilterOp {
+	if len(f.PrefixVal) == 0 {
+		f.PrefixVal = make(map[string]string)
+	}
+	f.PrefixVal[field] = prefix
+	return f
+}
+
+// Docs with a term of the field matching @regexp, @flags is "" for all the
+// operators, see MultiTermQuery.Flags
+//
+//    Filter().Regexp("zip", "[0-9]{5}", "")
+func (f *FilterOp) Regexp(field, regexp, flags string) *FilterOp {
+	if len(f.RegexpVal) == 0 {
+		f.RegexpVal = make(map[string]map[string]interface{})
+	}
+	f.RegexpVal[field] = map[string]interface{}{"value": regexp}
+	if flags != "" {
+		f.RegexpVal[field]["flags"] = flags
+	}
+	return f
+}
+
+// Filter on nested objects, or child or parent docs, see Nested
+//
+//    Filter().Join(HasChild("comment", Filter().Terms("author", "bob")))
+func (f *FilterOp) Join(j *JoinQuery) *FilterOp {
+	f.JoinVal = append(f.JoinVal, j)
+	return f
+}
+
+// Negate the whole op, see Not for negating other filters
 func (f *FilterOp) Not() *FilterOp {
 	f.not = true
 	return f
 }
 
-// Add another Filterop, "combines" two filter ops into one
+// Cache the result of the filter, or not, for filters cached by default.   Not all
+// filters take it, exists and missing for one are always cached.
+func (f *FilterOp) Cache(cache bool) *FilterOp {
+	f.cache = &cache
+	return f
+}
+
+// Name the filter, the hits list the names of the filters they matched
+func (f *FilterOp) Name(name string) *FilterOp {
+	f.name = name
+	return f
+}
+
+// Add another Filterop, "combines" two filter ops into one, both must match
 func (f *FilterOp) Add(fop *FilterOp) *FilterOp {
-	// TODO, this is invalid, refactor
-	if len(fop.Exist) > 0 {
-		f.Exist = fop.Exist
-	}
-	if len(fop.MissingVal) > 0 {
-		f.MissingVal = fop.MissingVal
-	}
-	if len(fop.Range) > 0 {
-		f.Range = fop.Range
-	}
+	f.added = append(f.added, fop)
 	return f
 }
 
-/*
-// Custom marshalling to support the query dsl 
+// Custom marshalling to support the query dsl.   Each filter type takes a single
+// field, so an op of several (terms of two fields, ranges, an exists and a
+// missing ...) is sent as an "and" of one filter each.
 func (f *FilterOp) MarshalJSON() ([]byte, error) {
-	// Ok, hope this doesn't break with go 1.04 as it is probably 
-	// capitializing on a bug:   to prevent this from becoming a recursive lock
-	// we derefrence, and pointers don't get passed here?
+	var filter interface{}
+	clauses := f.clauses()
+	switch len(clauses) {
+	case 0:
+		filter = withFilterOptions(map[string]interface{}{"match_all": struct{}{}}, f.cache, f.name)
+	case 1:
+		filter = withFilterOptions(clauses[0], f.cache, f.name)
+	default:
+		filter = map[string]interface{}{"and": clauses}
+		if f.cache != nil || f.name != "" {
+			and := map[string]interface{}{"filters": clauses}
+			setFilterOptions(and, f.cache, f.name)
+			filter = map[string]interface{}{"and": and}
+		}
+	}
 	if f.not {
-		m := map[string]FilterOp{"not": *f}
-		return json.Marshal(&m)
+		filter = map[string]interface{}{"not": filter}
 	}
-	return json.Marshal(*f)
+	return json.Marshal(filter)
+}
+
+func (f *FilterOp) clauses() []interface{} {
+	clauses := make([]interface{}, 0)
+	for _, field := range sortedKeys(f.TermsMap) {
+		clauses = append(clauses, map[string]interface{}{"terms": map[string]interface{}{field: f.TermsMap[field]}})
+	}
+	for _, field := range sortedKeys(f.Range) {
+		clauses = append(clauses, map[string]interface{}{"range": map[string]interface{}{field: f.Range[field]}})
+	}
+	if len(f.Exist) > 0 {
+		clauses = append(clauses, map[string]interface{}{"exists": map[string]interface{}{"field": f.Exist["field"]}})
+	}
+	if len(f.MissingVal) > 0 {
+		clauses = append(clauses, map[string]interface{}{"missing": map[string]interface{}{"field": f.MissingVal["field"]}})
+	}
+	for _, field := range sortedKeys(f.PrefixVal) {
+		clauses = append(clauses, map[string]interface{}{"prefix": map[string]interface{}{field: f.PrefixVal[field]}})
+	}
+	for _, field := range sortedKeys(f.RegexpVal) {
+		clauses = append(clauses, map[string]interface{}{"regexp": map[string]interface{}{field: f.RegexpVal[field]}})
+	}
+	for _, j := range f.JoinVal {
+		clauses = append(clauses, map[string]*JoinQuery{j.kind: j})
+	}
+	for _, geo := range f.GeoVal {
+		clauses = append(clauses, geo)
+	}
+	for _, fop := range f.added {
+		clauses = append(clauses, fop)
+	}
+	return clauses
+}
+
+// the options of a single filter go in it, or in an "and" of it for a filter that
+// is not an object of options
+func withFilterOptions(clause interface{}, cache *bool, name string) interface{} {
+	if cache == nil && name == "" {
+		return clause
+	}
+	if m, ok := clause.(map[string]interface{}); ok && len(m) == 1 {
+		for kind, inner := range m {
+			if opts, ok := inner.(map[string]interface{}); ok {
+				withOpts := make(map[string]interface{}, len(opts)+2)
+				for k, v := range opts {
+					withOpts[k] = v
+				}
+				setFilterOptions(withOpts, cache, name)
+				return map[string]interface{}{kind: withOpts}
+			}
+		}
+	}
+	and := map[string]interface{}{"filters": []interface{}{clause}}
+	setFilterOptions(and, cache, name)
+	return map[string]interface{}{"and": and}
+}
+
+// the keys of a map keyed by field, in order so the json is always the same
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch fm := m.(type) {
+	case map[string][]interface{}:
+		for k := range fm {
+			keys = append(keys, k)
+		}
+	case map[string]map[string]interface{}:
+		for k := range fm {
+			keys = append(keys, k)
+		}
+	case map[string]string:
+		for k := range fm {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
 }
-*/
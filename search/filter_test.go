@@ -1,7 +1,7 @@
 package search
 
 import (
-	//"encoding/json"
+	"encoding/json"
 	. "github.com/araddon/gou"
 	"testing"
 )
@@ -117,3 +117,55 @@ func TestFilterQueryClause(t *testing.T) {
 	Assert(out.Hits.Len() == 3, t, "Should have 3 docs %v", out.Hits.Len())
 	Assert(CloseInt(out.Hits.Total, 3), t, "Should have ~3 total= %v", out.Hits.Total)
 }
+
+func TestFilterTree(t *testing.T) {
+	// (A and (B or not C)), nested in a bool
+	b, err := json.Marshal(BoolFilter().
+		Must(And(
+			Filter().Terms("actor_attributes.location", "portland"),
+			Or(
+				Range().Field("repository.forks").Gte(100),
+				Not(Filter().Exists("repository.language")),
+			).Cache(true).Name("popular"),
+		)).
+		MustNot(Query().Match(Match("repository.description", "fork"))).
+		Should(Not(Filter().Terms("type", "PushEvent"), Filter().Missing("actor")).Cache(false)).
+		Name("all"))
+	Assert(err == nil, t, "should not have error %v", err)
+	expected := `{"bool":{"_name":"all","must":[{"and":[{"terms":{"actor_attributes.location":["portland"]}},` +
+		`{"or":{"_cache":true,"_name":"popular","filters":[{"range":{"repository.forks":{"gte":100}}},{"not":{"filter":{"exists":{"field":"repository.language"}}}}]}}]}],` +
+		`"must_not":[{"query":{"match":{"repository.description":{"query":"fork"}}}}],` +
+		`"should":[{"not":{"_cache":false,"filter":{"and":[{"terms":{"type":["PushEvent"]}},{"missing":{"field":"actor"}}]}}}]}}`
+	Assert(string(b) == expected, t, "Wrong filter tree %s", string(b))
+
+	// cache and name of a FilterOp go in its filter, or in an and of several
+	b, _ = json.Marshal(Filter().Terms("actor", "bob").Cache(true).Name("bob"))
+	Assert(string(b) == `{"terms":{"_cache":true,"_name":"bob","actor":["bob"]}}`, t, "Wrong filter options %s", string(b))
+	b, _ = json.Marshal(Range().Field("repository.forks").Gt(1).Field("repository.size").Gt(1).Not().Name("small"))
+	expected = `{"not":{"and":{"_name":"small","filters":[{"range":{"repository.forks":{"gt":1}}},{"range":{"repository.size":{"gt":1}}}]}}}`
+	Assert(string(b) == expected, t, "Wrong filter options %s", string(b))
+}
+
+func TestFilterClauses(t *testing.T) {
+	// a single filter is sent as is
+	qry := Search("github").Filter(Filter().Exists("actor"))
+	b, _ := json.Marshal(qry)
+	Assert(string(b) == `{"filter":{"exists":{"field":"actor"}}}`, t, "Wrong filter %s", string(b))
+
+	// the "or" of one call does not stick to the filters of the next
+	qry.Filter("or", Filter().Terms("actor", "bob"), Filter().Terms("actor", "alice"))
+	qry.Filter(Filter().Missing("repository.name"), "not", Filter().Terms("type", "PushEvent"), Query().Search("add"))
+	b, _ = json.Marshal(qry)
+	expected := `{"filter":{"and":[{"exists":{"field":"actor"}},{"or":[{"terms":{"actor":["bob"]}},{"terms":{"actor":["alice"]}}]},` +
+		`{"missing":{"field":"repository.name"}},{"not":{"filter":{"terms":{"type":["PushEvent"]}}}},{"not":{"filter":{"query":{"query_string":{"query":"add"}}}}}]}}`
+	Assert(string(b) == expected, t, "Wrong filters %s", string(b))
+
+	// as well as tree nodes
+	b, _ = json.Marshal(Search("github").Filter(Or(Filter().Exists("actor"), And())))
+	Assert(string(b) == `{"filter":{"or":[{"exists":{"field":"actor"}},{"and":[]}]}}`, t, "Wrong filter node %s", string(b))
+
+	// queries with filters, in one call
+	b, _ = json.Marshal(Search("github").Filter(Filter().Exists("actor"), Query().Search("add")))
+	expected = `{"filter":{"and":[{"exists":{"field":"actor"}},{"query":{"query_string":{"query":"add"}}}]}}`
+	Assert(string(b) == expected, t, "Wrong filter and query %s", string(b))
+}
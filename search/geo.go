@@ -0,0 +1,151 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+	"filter": {
+	  "geo_distance": {
+	    "distance": "10km",
+	    "location": {"lat": 45.52, "lon": -122.68}
+	  }
+	}
+
+	"filter": {
+	  "geo_bounding_box": {
+	    "location": {
+	      "top_left": {"lat": 45.6, "lon": -122.8},
+	      "bottom_right": {"lat": 45.4, "lon": -122.5}
+	    }
+	  }
+	}
+*/
+
+// A geo point, of a lat/lon or a geohash.   It is sent as {"lat":..,"lon":..} or
+// the geohash, and read from any of the forms elasticsearch takes: an object, a
+// "lat,lon" string, a geohash or a [lon,lat] array.
+type GeoPoint struct {
+	Lat     float64
+	Lon     float64
+	Geohash string
+}
+
+func LatLon(lat, lon float64) GeoPoint {
+	return GeoPoint{Lat: lat, Lon: lon}
+}
+
+func Geohash(hash string) GeoPoint {
+	return GeoPoint{Geohash: hash}
+}
+
+func (p GeoPoint) MarshalJSON() ([]byte, error) {
+	if p.Geohash != "" {
+		return json.Marshal(p.Geohash)
+	}
+	return json.Marshal(map[string]float64{"lat": p.Lat, "lon": p.Lon})
+}
+
+func (p *GeoPoint) UnmarshalJSON(data []byte) error {
+	switch {
+	case len(data) > 0 && data[0] == '[':
+		var lonLat []float64
+		if err := json.Unmarshal(data, &lonLat); err != nil {
+			return err
+		}
+		if len(lonLat) != 2 {
+			return fmt.Errorf("geo point should be [lon,lat], was %s", data)
+		}
+		*p = LatLon(lonLat[1], lonLat[0])
+	case len(data) > 0 && data[0] == '"':
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		parts := strings.Split(s, ",")
+		if len(parts) != 2 {
+			*p = Geohash(s)
+			return nil
+		}
+		lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		if err != nil {
+			return fmt.Errorf("geo point should be \"lat,lon\", was %s", data)
+		}
+		lon, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			return fmt.Errorf("geo point should be \"lat,lon\", was %s", data)
+		}
+		*p = LatLon(lat, lon)
+	default:
+		var latLon struct {
+			Lat float64 `json:"lat"`
+			Lon float64 `json:"lon"`
+		}
+		if err := json.Unmarshal(data, &latLon); err != nil {
+			return err
+		}
+		*p = LatLon(latLon.Lat, latLon.Lon)
+	}
+	return nil
+}
+
+// A shape for a geo_shape filter, in GeoJSON, such as
+//
+//    Shape{Type: "envelope", Coordinates: [][]float64{{-122.8, 45.6}, {-122.5, 45.4}}}
+//    Shape{Type: "circle", Coordinates: []float64{-122.68, 45.52}, Radius: "10km"}
+type Shape struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+	Radius      string      `json:"radius,omitempty"`
+}
+
+// A shape already indexed in a doc, for a geo_shape filter
+type IndexedShape struct {
+	Id    string `json:"id"`
+	Type  string `json:"type"`
+	Index string `json:"index,omitempty"` // default "shapes"
+	Path  string `json:"path,omitempty"`  // default "shape"
+}
+
+// Docs with the geo point @field within @distance ("10km", "5mi") of @point
+//
+//    Filter().GeoDistance("location", LatLon(45.52, -122.68), "10km")
+func (f *FilterOp) GeoDistance(field string, point GeoPoint, distance string) *FilterOp {
+	return f.geo("geo_distance", map[string]interface{}{field: point, "distance": distance})
+}
+
+// Docs with the geo point @field from @from to @to away of @point
+func (f *FilterOp) GeoDistanceRange(field string, point GeoPoint, from, to string) *FilterOp {
+	return f.geo("geo_distance_range", map[string]interface{}{field: point, "from": from, "to": to})
+}
+
+// Docs with the geo point @field within the box
+func (f *FilterOp) GeoBoundingBox(field string, topLeft, bottomRight GeoPoint) *FilterOp {
+	box := map[string]GeoPoint{"top_left": topLeft, "bottom_right": bottomRight}
+	return f.geo("geo_bounding_box", map[string]interface{}{field: box})
+}
+
+// Docs with the geo point @field within the polygon of @points
+func (f *FilterOp) GeoPolygon(field string, points ...GeoPoint) *FilterOp {
+	return f.geo("geo_polygon", map[string]interface{}{field: map[string][]GeoPoint{"points": points}})
+}
+
+// Docs with the geo shape @field intersecting @shape, a *Shape or an *IndexedShape
+//
+//    Filter().GeoShape("area", &Shape{Type: "envelope", Coordinates: [][]float64{{13, 53}, {14, 52}}})
+//    Filter().GeoShape("area", &IndexedShape{Id: "portland", Type: "city"})
+func (f *FilterOp) GeoShape(field string, shape interface{}) *FilterOp {
+	switch shape.(type) {
+	case *IndexedShape:
+		return f.geo("geo_shape", map[string]interface{}{field: map[string]interface{}{"indexed_shape": shape}})
+	}
+	return f.geo("geo_shape", map[string]interface{}{field: map[string]interface{}{"shape": shape}})
+}
+
+func (f *FilterOp) geo(filter string, opts map[string]interface{}) *FilterOp {
+	f.GeoVal = append(f.GeoVal, map[string]interface{}{filter: opts})
+	return f
+}
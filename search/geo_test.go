@@ -0,0 +1,55 @@
+package search
+
+import (
+	"encoding/json"
+	. "github.com/araddon/gou"
+	"testing"
+)
+
+func TestGeoPoint(t *testing.T) {
+	var doc struct {
+		Obj, LatLon, Hash, LonLat GeoPoint
+	}
+	err := json.Unmarshal([]byte(`{"Obj":{"lat":45.5,"lon":-122.5},"LatLon":"45.5, -122.5","Hash":"c20g","LonLat":[-122.5,45.5]}`), &doc)
+	Assert(err == nil, t, "should not have error %v", err)
+	Assert(doc.Obj == LatLon(45.5, -122.5) && doc.LatLon == doc.Obj && doc.LonLat == doc.Obj, t, "Wrong points %v", doc)
+	Assert(doc.Hash == Geohash("c20g"), t, "Wrong geohash %v", doc.Hash)
+	Assert(json.Unmarshal([]byte(`[1,2,3]`), &doc.Obj) != nil, t, "Should not read 3 coordinates")
+
+	b, _ := json.Marshal(doc)
+	expected := `{"Obj":{"lat":45.5,"lon":-122.5},"LatLon":{"lat":45.5,"lon":-122.5},"Hash":"c20g","LonLat":{"lat":45.5,"lon":-122.5}}`
+	Assert(string(b) == expected, t, "Wrong points json %s", string(b))
+}
+
+func TestGeoFilters(t *testing.T) {
+	here := LatLon(45.5, -122.5)
+	b, err := json.Marshal(Filter().GeoDistance("location", here, "10km"))
+	Assert(err == nil, t, "should not have error %v", err)
+	Assert(string(b) == `{"geo_distance":{"distance":"10km","location":{"lat":45.5,"lon":-122.5}}}`, t, "Wrong geo_distance %s", string(b))
+
+	b, _ = json.Marshal(Filter().GeoDistanceRange("location", Geohash("c20g"), "1km", "2km").
+		GeoBoundingBox("location", LatLon(46, -123), LatLon(45, -122)))
+	expected := `{"and":[{"geo_distance_range":{"from":"1km","location":"c20g","to":"2km"}},` +
+		`{"geo_bounding_box":{"location":{"bottom_right":{"lat":45,"lon":-122},"top_left":{"lat":46,"lon":-123}}}}]}`
+	Assert(string(b) == expected, t, "Wrong geo filters %s", string(b))
+
+	b, _ = json.Marshal(Filter().GeoPolygon("location", LatLon(40, -70), LatLon(30, -80), LatLon(20, -90)))
+	expected = `{"geo_polygon":{"location":{"points":[{"lat":40,"lon":-70},{"lat":30,"lon":-80},{"lat":20,"lon":-90}]}}}`
+	Assert(string(b) == expected, t, "Wrong geo_polygon %s", string(b))
+
+	b, _ = json.Marshal(Filter().GeoShape("area", &Shape{Type: "envelope", Coordinates: [][]float64{{13, 53}, {14, 52}}}).
+		GeoShape("area", &IndexedShape{Id: "portland", Type: "city"}))
+	expected = `{"and":[{"geo_shape":{"area":{"shape":{"type":"envelope","coordinates":[[13,53],[14,52]]}}}},` +
+		`{"geo_shape":{"area":{"indexed_shape":{"id":"portland","type":"city"}}}}]}`
+	Assert(string(b) == expected, t, "Wrong geo_shape %s", string(b))
+}
+
+func TestGeoDistanceSort(t *testing.T) {
+	b, err := json.Marshal(Search("stores").Sort(Sort("location").GeoDistance(LatLon(45.5, -122.5)).Unit("mi").Desc(), Sort("name")))
+	Assert(err == nil, t, "should not have error %v", err)
+	expected := `{"sort":[{"_geo_distance":{"location":{"lat":45.5,"lon":-122.5},"order":"desc","unit":"mi"}},"name"]}`
+	Assert(string(b) == expected, t, "Wrong sort %s", string(b))
+
+	b, _ = json.Marshal(Sort("location").GeoDistance(Geohash("c20g"), Geohash("c21h")))
+	Assert(string(b) == `{"_geo_distance":{"location":["c20g","c21h"],"order":"asc"}}`, t, "Wrong sort %s", string(b))
+}
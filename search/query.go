@@ -3,6 +3,7 @@ package search
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/mattbaird/elastigo/core"
 	//"log"
 	"strings"
 )
@@ -53,19 +54,45 @@ type QueryEmbed struct {
 	MatchAll *MatchAll         `json:"match_all,omitempty"`
 	Terms    map[string]string `json:"term,omitempty"`
 	Qs       *QueryString      `json:"query_string,omitempty"`
+	MLT      *core.MLT         `json:"more_like_this,omitempty"`
+	BoolVal  *BoolQuery        `json:"bool,omitempty"`
+
+	MatchVal             *MatchQuery `json:"match,omitempty"`
+	MatchPhraseVal       *MatchQuery `json:"match_phrase,omitempty"`
+	MatchPhrasePrefixVal *MatchQuery `json:"match_phrase_prefix,omitempty"`
+	MultiMatchVal        *MatchQuery `json:"multi_match,omitempty"`
+
+	RangeVal map[string]map[string]interface{} `json:"range,omitempty"`
+
+	PrefixVal   *MultiTermQuery `json:"prefix,omitempty"`
+	WildcardVal *MultiTermQuery `json:"wildcard,omitempty"`
+	RegexpVal   *MultiTermQuery `json:"regexp,omitempty"`
+	FuzzyVal    *MultiTermQuery `json:"fuzzy,omitempty"`
+
+	NestedVal      *JoinQuery `json:"nested,omitempty"`
+	HasChildVal    *JoinQuery `json:"has_child,omitempty"`
+	HasParentVal   *JoinQuery `json:"has_parent,omitempty"`
+	TopChildrenVal *JoinQuery `json:"top_children,omitempty"`
+
+	FunctionScoreVal *FunctionScoreQuery `json:"function_score,omitempty"`
 	//Exist    string            `json:"_exists_,omitempty"`
 }
 
 // Custom marshalling to support the query dsl which is a conditional
-// json format, not always the same parent/children 
+// json format, not always the same parent/children.   As each query is of one
+// kind, and term and range queries of one field, several are combined in a bool
+// query that must match them all.   A query with only a filter matches all the
+// documents it lets through.
 func (qd *QueryDsl) MarshalJSON() ([]byte, error) {
 	q := qd.QueryEmbed
-	hasQuery := false
-	if q.Qs != nil || len(q.Terms) > 0 || q.MatchAll != nil {
-		hasQuery = true
+	clauses := q.clauses()
+	if len(clauses) > 1 {
+		q = QueryEmbed{BoolVal: Bool().Must(clauses...)}
 	}
-	// If a query has a 
-	if qd.FilterVal != nil && hasQuery {
+	if qd.FilterVal != nil {
+		if len(clauses) == 0 {
+			q.MatchAll = &MatchAll{""}
+		}
 		queryB, err := json.Marshal(q)
 		if err != nil {
 			return queryB, err
@@ -79,23 +106,110 @@ func (qd *QueryDsl) MarshalJSON() ([]byte, error) {
 	return json.Marshal(q)
 }
 
+// each query set, as a query of its own
+func (q *QueryEmbed) clauses() []*QueryDsl {
+	clauses := make([]*QueryDsl, 0)
+	add := func(e QueryEmbed) {
+		clauses = append(clauses, &QueryDsl{QueryEmbed: e})
+	}
+	if q.MatchAll != nil {
+		add(QueryEmbed{MatchAll: q.MatchAll})
+	}
+	for _, field := range sortedKeys(q.Terms) {
+		add(QueryEmbed{Terms: map[string]string{field: q.Terms[field]}})
+	}
+	if q.Qs != nil {
+		add(QueryEmbed{Qs: q.Qs})
+	}
+	if q.MLT != nil {
+		add(QueryEmbed{MLT: q.MLT})
+	}
+	if q.BoolVal != nil {
+		add(QueryEmbed{BoolVal: q.BoolVal})
+	}
+	if q.MatchVal != nil {
+		add(QueryEmbed{MatchVal: q.MatchVal})
+	}
+	if q.MatchPhraseVal != nil {
+		add(QueryEmbed{MatchPhraseVal: q.MatchPhraseVal})
+	}
+	if q.MatchPhrasePrefixVal != nil {
+		add(QueryEmbed{MatchPhrasePrefixVal: q.MatchPhrasePrefixVal})
+	}
+	if q.MultiMatchVal != nil {
+		add(QueryEmbed{MultiMatchVal: q.MultiMatchVal})
+	}
+	for _, field := range sortedKeys(q.RangeVal) {
+		add(QueryEmbed{RangeVal: map[string]map[string]interface{}{field: q.RangeVal[field]}})
+	}
+	if q.PrefixVal != nil {
+		add(QueryEmbed{PrefixVal: q.PrefixVal})
+	}
+	if q.WildcardVal != nil {
+		add(QueryEmbed{WildcardVal: q.WildcardVal})
+	}
+	if q.RegexpVal != nil {
+		add(QueryEmbed{RegexpVal: q.RegexpVal})
+	}
+	if q.FuzzyVal != nil {
+		add(QueryEmbed{FuzzyVal: q.FuzzyVal})
+	}
+	if q.NestedVal != nil {
+		add(QueryEmbed{NestedVal: q.NestedVal})
+	}
+	if q.HasChildVal != nil {
+		add(QueryEmbed{HasChildVal: q.HasChildVal})
+	}
+	if q.HasParentVal != nil {
+		add(QueryEmbed{HasParentVal: q.HasParentVal})
+	}
+	if q.TopChildrenVal != nil {
+		add(QueryEmbed{TopChildrenVal: q.TopChildrenVal})
+	}
+	if q.FunctionScoreVal != nil {
+		add(QueryEmbed{FunctionScoreVal: q.FunctionScoreVal})
+	}
+	return clauses
+}
+
 // all documents
 func (q *QueryDsl) All() *QueryDsl {
 	q.MatchAll = &MatchAll{""}
 	return q
 }
 
-// Limit the query to this range
+// Limit the query to this range, as a filter that is and-ed with any other
 func (q *QueryDsl) Range(fop *FilterOp) *QueryDsl {
 	if q.FilterVal == nil {
 		q.FilterVal = fop
 		return q
 	}
-	// TODO:  this is not valid, refactor
 	q.FilterVal.Add(fop)
 	return q
 }
 
+// Search the ranges of @fop with a range query, which unlike the Range filter
+// can be scored, boosted and nested in other queries.   The docs must be in all
+// the ranges.
+//
+//    Query().RangeQuery(Range().Field("age").Gte(18).Lt(65))
+func (q *QueryDsl) RangeQuery(fop *FilterOp) *QueryDsl {
+	if q.RangeVal == nil {
+		q.RangeVal = make(map[string]map[string]interface{})
+	}
+	for field, opts := range fop.Range {
+		cur, ok := q.RangeVal[field]
+		if !ok {
+			cur = make(map[string]interface{})
+			q.RangeVal[field] = cur
+		}
+		for name, val := range opts {
+			cur[name] = val
+		}
+	}
+	return q
+}
+
 // Add a term search for a specific field 
 //    Term("user","kimchy")
 func (q *QueryDsl) Term(name, value string) *QueryDsl {
@@ -115,6 +229,14 @@ func (q *QueryDsl) Search(searchFor string) *QueryDsl {
 	return q
 }
 
+// Find documents "like" the given text, in the given fields
+//
+//    Query().MoreLikeThis(core.MLT{Fields: []string{"repository.description"}, LikeText: "javascript testing", MinTermFrequency: 1})
+func (q *QueryDsl) MoreLikeThis(mlt core.MLT) *QueryDsl {
+	q.QueryEmbed.MLT = &mlt
+	return q
+}
+
 // Querystring operations
 func (q *QueryDsl) Qs(qs *QueryString) *QueryDsl {
 	q.QueryEmbed.Qs = qs
@@ -144,12 +266,525 @@ func (q *QueryDsl) Fields(fields, search, exists, missing string) *QueryDsl {
 	return q
 }
 
+// Combine queries with a bool query, see Bool
+func (q *QueryDsl) Bool(b *BoolQuery) *QueryDsl {
+	q.QueryEmbed.BoolVal = b
+	return q
+}
+
+// Search analyzed text with a match, match_phrase, match_phrase_prefix or
+// multi_match query, see Match
+func (q *QueryDsl) Match(m *MatchQuery) *QueryDsl {
+	switch m.kind {
+	case "match_phrase":
+		q.QueryEmbed.MatchPhraseVal = m
+	case "match_phrase_prefix":
+		q.QueryEmbed.MatchPhrasePrefixVal = m
+	case "multi_match":
+		q.QueryEmbed.MultiMatchVal = m
+	default:
+		q.QueryEmbed.MatchVal = m
+	}
+	return q
+}
+
+// Search the terms of a field matching a prefix, wildcard, regexp or fuzzy query,
+// see Prefix
+func (q *QueryDsl) MultiTerm(m *MultiTermQuery) *QueryDsl {
+	switch m.kind {
+	case "wildcard":
+		q.QueryEmbed.WildcardVal = m
+	case "regexp":
+		q.QueryEmbed.RegexpVal = m
+	case "fuzzy":
+		q.QueryEmbed.FuzzyVal = m
+	default:
+		q.QueryEmbed.PrefixVal = m
+	}
+	return q
+}
+
+// Search nested objects, or child or parent documents, see Nested
+func (q *QueryDsl) Join(j *JoinQuery) *QueryDsl {
+	switch j.kind {
+	case "has_child":
+		q.QueryEmbed.HasChildVal = j
+	case "has_parent":
+		q.QueryEmbed.HasParentVal = j
+	case "top_children":
+		q.QueryEmbed.TopChildrenVal = j
+	default:
+		q.QueryEmbed.NestedVal = j
+	}
+	return q
+}
+
+// Score the docs with functions, see FunctionScore
+func (q *QueryDsl) FunctionScore(fs *FunctionScoreQuery) *QueryDsl {
+	q.QueryEmbed.FunctionScoreVal = fs
+	return q
+}
+
 // Filter this query 
 func (q *QueryDsl) Filter(f *FilterOp) *QueryDsl {
 	q.FilterVal = f
 	return q
 }
 
+/*
+	"query": {
+	  "bool": {
+	    "must": [{"term": {"actor": "bob"}}],
+	    "should": [
+	      {"term": {"repository.language": "go"}},
+	      {"term": {"repository.language": "python"}}
+	    ],
+	    "must_not": [{"term": {"type": "PushEvent"}}],
+	    "minimum_should_match": "1",
+	    "boost": 2
+	  }
+	}
+*/
+
+// A bool query combines other queries, the docs must match all the Must queries
+// and none of the MustNot, the Should queries add to the score or, without any
+// Must, at least one of them (or MinimumShouldMatch) must match.   Any QueryDsl
+// may be used, including other bool queries:
+//
+//    Query().Bool(
+//        Bool().Must(Query().Term("actor", "bob")).
+//            Should(Query().Term("repository.language", "go"), Query().Term("repository.language", "python")).
+//            MustNot(Query().Bool(Bool().Must(Query().Term("type", "PushEvent")))).
+//            MinimumShouldMatch("1"),
+//    )
+func Bool() *BoolQuery {
+	return &BoolQuery{}
+}
+
+type BoolQuery struct {
+	MustVal        []*QueryDsl `json:"must,omitempty"`
+	ShouldVal      []*QueryDsl `json:"should,omitempty"`
+	MustNotVal     []*QueryDsl `json:"must_not,omitempty"`
+	MinShouldMatch string      `json:"minimum_should_match,omitempty"`
+	BoostVal       float64     `json:"boost,omitempty"`
+}
+
+func (b *BoolQuery) Must(queries ...*QueryDsl) *BoolQuery {
+	b.MustVal = append(b.MustVal, queries...)
+	return b
+}
+
+func (b *BoolQuery) Should(queries ...*QueryDsl) *BoolQuery {
+	b.ShouldVal = append(b.ShouldVal, queries...)
+	return b
+}
+
+func (b *BoolQuery) MustNot(queries ...*QueryDsl) *BoolQuery {
+	b.MustNotVal = append(b.MustNotVal, queries...)
+	return b
+}
+
+// How many of the Should queries must match, a count "2", a percentage "75%" or
+// a combination such as "3<90%"
+func (b *BoolQuery) MinimumShouldMatch(min string) *BoolQuery {
+	b.MinShouldMatch = min
+	return b
+}
+
+func (b *BoolQuery) Boost(boost float64) *BoolQuery {
+	b.BoostVal = boost
+	return b
+}
+
+/*
+	"query": {
+	  "match": {
+	    "message": {
+	      "query": "this is a test",
+	      "operator": "and",
+	      "zero_terms_query": "all"
+	    }
+	  }
+	}
+
+	"query": {
+	  "multi_match": {
+	    "query": "this is a test",
+	    "fields": ["subject^3", "message"]
+	  }
+	}
+*/
+
+// A match query analyzes the text and searches for its terms in the field.   Unlike
+// a query_string, there is no syntax in the text so user input can't make it
+// fail, which makes it the one to use for a search box.
+//
+//    Query().Match(Match("message", userText).Operator("and").Fuzziness("0.8"))
+func Match(field, text string) *MatchQuery {
+	return &MatchQuery{kind: "match", field: field, QueryVal: text}
+}
+
+// Match the terms as a phrase, in order, with up to Slop other terms between them
+//
+//    Query().Match(MatchPhrase("message", "this is a test").Slop(2))
+func MatchPhrase(field, text string) *MatchQuery {
+	return &MatchQuery{kind: "match_phrase", field: field, QueryVal: text}
+}
+
+// Match a phrase where the last term is a prefix, for search as you type
+//
+//    Query().Match(MatchPhrasePrefix("message", "this is a te").MaxExpansions(10))
+func MatchPhrasePrefix(field, text string) *MatchQuery {
+	return &MatchQuery{kind: "match_phrase_prefix", field: field, QueryVal: text}
+}
+
+// Match the text in any of several fields, each may have a boost "subject^3"
+//
+//    Query().Match(MultiMatch("this is a test", "subject^3", "message"))
+func MultiMatch(text string, fields ...string) *MatchQuery {
+	return &MatchQuery{kind: "multi_match", QueryVal: text, FieldsVal: fields}
+}
+
+type MatchQuery struct {
+	kind  string
+	field string
+
+	QueryVal           string   `json:"query"`
+	FieldsVal          []string `json:"fields,omitempty"`
+	OperatorVal        string   `json:"operator,omitempty"`
+	AnalyzerVal        string   `json:"analyzer,omitempty"`
+	FuzzinessVal       string   `json:"fuzziness,omitempty"`
+	PrefixLengthVal    int      `json:"prefix_length,omitempty"`
+	MaxExpansionsVal   int      `json:"max_expansions,omitempty"`
+	MinShouldMatch     string   `json:"minimum_should_match,omitempty"`
+	CutoffFrequencyVal float64  `json:"cutoff_frequency,omitempty"`
+	ZeroTermsQueryVal  string   `json:"zero_terms_query,omitempty"`
+	SlopVal            int      `json:"slop,omitempty"`
+	BoostVal           float64  `json:"boost,omitempty"`
+}
+
+// Add a field to a multi_match, with a boost if not 0
+func (m *MatchQuery) Field(field string, boost float64) *MatchQuery {
+	if boost != 0 {
+		field = fmt.Sprintf("%s^%g", field, boost)
+	}
+	m.FieldsVal = append(m.FieldsVal, field)
+	return m
+}
+
+// How the terms of the text combine [or, and], default or
+func (m *MatchQuery) Operator(operator string) *MatchQuery {
+	m.OperatorVal = operator
+	return m
+}
+
+func (m *MatchQuery) Analyzer(analyzer string) *MatchQuery {
+	m.AnalyzerVal = analyzer
+	return m
+}
+
+// Match terms within this edit distance "2" or similarity "0.8"
+func (m *MatchQuery) Fuzziness(fuzziness string) *MatchQuery {
+	m.FuzzinessVal = fuzziness
+	return m
+}
+
+// Number of leading characters that must match exactly for a fuzzy match
+func (m *MatchQuery) PrefixLength(length int) *MatchQuery {
+	m.PrefixLengthVal = length
+	return m
+}
+
+// Max number of terms a fuzzy term or a phrase prefix expands to
+func (m *MatchQuery) MaxExpansions(max int) *MatchQuery {
+	m.MaxExpansionsVal = max
+	return m
+}
+
+// How many of the terms must match with the or operator, "2", "75%" ...
+func (m *MatchQuery) MinimumShouldMatch(min string) *MatchQuery {
+	m.MinShouldMatch = min
+	return m
+}
+
+// Terms more frequent than this (a fraction of the docs, or a count if at least 1)
+// only count towards the score of docs matching the rarer terms
+func (m *MatchQuery) CutoffFrequency(freq float64) *MatchQuery {
+	m.CutoffFrequencyVal = freq
+	return m
+}
+
+// What matches when the analyzer removes all the terms [none, all], default none
+func (m *MatchQuery) ZeroTermsQuery(zeroTerms string) *MatchQuery {
+	m.ZeroTermsQueryVal = zeroTerms
+	return m
+}
+
+// Number of other terms allowed between the terms of a phrase
+func (m *MatchQuery) Slop(slop int) *MatchQuery {
+	m.SlopVal = slop
+	return m
+}
+
+func (m *MatchQuery) Boost(boost float64) *MatchQuery {
+	m.BoostVal = boost
+	return m
+}
+
+// the options are under the field name, other than for multi_match
+func (m *MatchQuery) MarshalJSON() ([]byte, error) {
+	// a conversion drops the methods, so this does not recurse
+	type matchOptions MatchQuery
+	if m.kind == "multi_match" {
+		return json.Marshal((*matchOptions)(m))
+	}
+	return json.Marshal(map[string]*matchOptions{m.field: (*matchOptions)(m)})
+}
+
+/*
+	"query": {
+	  "prefix": {
+	    "sku": { "value": "AB-12", "boost": 2 }
+	  }
+	}
+
+	"query": {
+	  "regexp": {
+	    "name.first": { "value": "s.*y", "flags": "INTERSECTION|COMPLEMENT|EMPTY" }
+	  }
+	}
+*/
+
+// Docs with a term of the field starting with @prefix, the value is not analyzed
+//
+//    Query().MultiTerm(Prefix("sku", "AB-12"))
+func Prefix(field, prefix string) *MultiTermQuery {
+	return &MultiTermQuery{kind: "prefix", field: field, ValueVal: prefix}
+}
+
+// Docs with a term of the field matching @pattern, where * is any characters and ?
+// a single one.   A leading wildcard has to go through all the terms, so is slow.
+//
+//    Query().MultiTerm(Wildcard("code", "X?-*-2013"))
+func Wildcard(field, pattern string) *MultiTermQuery {
+	return &MultiTermQuery{kind: "wildcard", field: field, ValueVal: pattern}
+}
+
+// Docs with a term of the field matching the lucene regular expression @regexp,
+// which is anchored to the whole term
+//
+//    Query().MultiTerm(Regexp("zip", "[0-9]{5}(-[0-9]{4})?").Flags("NONE"))
+func Regexp(field, regexp string) *MultiTermQuery {
+	return &MultiTermQuery{kind: "regexp", field: field, ValueVal: regexp}
+}
+
+// Docs with a term of the field similar to @value, for typos
+//
+//    Query().MultiTerm(Fuzzy("name", "jonh").Fuzziness("2").PrefixLength(1))
+func Fuzzy(field, value string) *MultiTermQuery {
+	return &MultiTermQuery{kind: "fuzzy", field: field, ValueVal: value}
+}
+
+// A query of the terms matching a pattern, see Prefix, Wildcard, Regexp and Fuzzy
+type MultiTermQuery struct {
+	kind  string
+	field string
+
+	ValueVal         string  `json:"value"`
+	BoostVal         float64 `json:"boost,omitempty"`
+	RewriteVal       string  `json:"rewrite,omitempty"`
+	FlagsVal         string  `json:"flags,omitempty"`
+	FuzzinessVal     string  `json:"fuzziness,omitempty"`
+	PrefixLengthVal  int     `json:"prefix_length,omitempty"`
+	MaxExpansionsVal int     `json:"max_expansions,omitempty"`
+}
+
+func (m *MultiTermQuery) Boost(boost float64) *MultiTermQuery {
+	m.BoostVal = boost
+	return m
+}
+
+// How the matching terms are searched and scored [constant_score_auto,
+// scoring_boolean, constant_score_boolean, constant_score_filter, top_terms_N,
+// top_terms_boost_N]
+func (m *MultiTermQuery) Rewrite(rewrite string) *MultiTermQuery {
+	m.RewriteVal = rewrite
+	return m
+}
+
+// The regexp operators allowed, "|" separated [ALL, ANYSTRING, AUTOMATON, COMPLEMENT,
+// EMPTY, INTERSECTION, INTERVAL, NONE]
+func (m *MultiTermQuery) Flags(flags string) *MultiTermQuery {
+	m.FlagsVal = flags
+	return m
+}
+
+// The max edit distance "2", or similarity "0.8", of a fuzzy match
+func (m *MultiTermQuery) Fuzziness(fuzziness string) *MultiTermQuery {
+	m.FuzzinessVal = fuzziness
+	return m
+}
+
+// Number of leading characters that must match exactly for a fuzzy match
+func (m *MultiTermQuery) PrefixLength(length int) *MultiTermQuery {
+	m.PrefixLengthVal = length
+	return m
+}
+
+// Max number of terms a fuzzy query expands to
+func (m *MultiTermQuery) MaxExpansions(max int) *MultiTermQuery {
+	m.MaxExpansionsVal = max
+	return m
+}
+
+// the options are under the field name
+func (m *MultiTermQuery) MarshalJSON() ([]byte, error) {
+	type multiTermOptions MultiTermQuery
+	return json.Marshal(map[string]*multiTermOptions{m.field: (*multiTermOptions)(m)})
+}
+
+/*
+	"query": {
+	  "nested": {
+	    "path": "items",
+	    "score_mode": "avg",
+	    "query": {"term": {"items.sku": "AB-12"}}
+	  }
+	}
+
+	"filter": {
+	  "has_child": {
+	    "type": "comment",
+	    "filter": {"term": {"author": "bob"}}
+	  }
+	}
+*/
+
+// Docs with a nested object at @path matching @inner, a query or a filter, see
+// JoinInner.   Used as a query with QueryDsl.Join, or as a filter with FilterOp.Join.
+//
+//    Query().Join(Nested("items", Query().Term("items.sku", "AB-12")).ScoreMode("max"))
+//
+//    Filter().Join(Nested("items", Range().Field("items.price").Gt(100)))
+func Nested(path string, inner JoinInner) *JoinQuery {
+	return newJoin("nested", inner).setPath(path)
+}
+
+// Parent docs with a child of @childType matching @inner
+//
+//    Query().Join(HasChild("comment", Query().Match(Match("body", "late delivery"))).ScoreMode("sum"))
+func HasChild(childType string, inner JoinInner) *JoinQuery {
+	return newJoin("has_child", inner).setType(childType)
+}
+
+// Child docs whose parent of @parentType matches @inner
+//
+//    Filter().Join(HasParent("order", Filter().Terms("status", "shipped")))
+func HasParent(parentType string, inner JoinInner) *JoinQuery {
+	return newJoin("has_parent", inner).setParentType(parentType)
+}
+
+// Parent docs of the top scoring children of @childType matching the @query, only
+// a query
+//
+//    Query().Join(TopChildren("comment", Query().Term("tag", "urgent")).ScoreMode("sum").Factor(10))
+func TopChildren(childType string, query *QueryDsl) *JoinQuery {
+	return newJoin("top_children", query).setType(childType)
+}
+
+// A query or filter of other docs than the ones searched, nested objects or parent
+// and child docs, see Nested, HasChild, HasParent and TopChildren
+type JoinQuery struct {
+	kind string
+
+	PathVal              string      `json:"path,omitempty"`
+	TypeVal              string      `json:"type,omitempty"`
+	ParentTypeVal        string      `json:"parent_type,omitempty"`
+	ScoreModeVal         string      `json:"score_mode,omitempty"`
+	ScoreVal             string      `json:"score,omitempty"`
+	FactorVal            int         `json:"factor,omitempty"`
+	IncrementalFactorVal int         `json:"incremental_factor,omitempty"`
+	BoostVal             float64     `json:"boost,omitempty"`
+	QueryVal             *QueryDsl   `json:"query,omitempty"`
+	FilterVal            interface{} `json:"filter,omitempty"`
+}
+
+// What a JoinQuery matches the other docs with, a *QueryDsl, or a filter: a
+// *FilterOp, *FilterNode or *BoolFilterNode
+type JoinInner interface {
+	joinInner(j *JoinQuery)
+}
+
+func (q *QueryDsl) joinInner(j *JoinQuery) {
+	j.QueryVal = q
+}
+
+func (f *FilterOp) joinInner(j *JoinQuery) {
+	j.FilterVal = f
+}
+
+func (f *FilterNode) joinInner(j *JoinQuery) {
+	j.FilterVal = f
+}
+
+func (f *BoolFilterNode) joinInner(j *JoinQuery) {
+	j.FilterVal = f
+}
+
+func newJoin(kind string, inner JoinInner) *JoinQuery {
+	j := &JoinQuery{kind: kind}
+	if inner != nil {
+		inner.joinInner(j)
+	}
+	return j
+}
+
+func (j *JoinQuery) setPath(path string) *JoinQuery {
+	j.PathVal = path
+	return j
+}
+
+func (j *JoinQuery) setType(docType string) *JoinQuery {
+	j.TypeVal = docType
+	return j
+}
+
+func (j *JoinQuery) setParentType(parentType string) *JoinQuery {
+	j.ParentTypeVal = parentType
+	return j
+}
+
+// How the scores of the matching docs make the score of the doc, only for queries
+//    nested [avg, total, max, none]
+//    has_child [none, sum, max, avg]
+//    has_parent [none, score]
+//    top_children [max, sum, avg]
+func (j *JoinQuery) ScoreMode(mode string) *JoinQuery {
+	if j.kind == "top_children" {
+		j.ScoreVal = mode
+	} else {
+		j.ScoreModeVal = mode
+	}
+	return j
+}
+
+// How many child docs top_children fetches per hit wanted, default 5
+func (j *JoinQuery) Factor(factor int) *JoinQuery {
+	j.FactorVal = factor
+	return j
+}
+
+// How much top_children raises the factor by when it has not found enough, default 2
+func (j *JoinQuery) IncrementalFactor(factor int) *JoinQuery {
+	j.IncrementalFactorVal = factor
+	return j
+}
+
+func (j *JoinQuery) Boost(boost float64) *JoinQuery {
+	j.BoostVal = boost
+	return j
+}
+
 type MatchAll struct {
 	All string `json:"-"`
 }
@@ -177,7 +812,8 @@ type QueryString struct {
 
 // Generic Term based (used in query, facet, filter)
 type Term struct {
-	Terms *Terms `json:"terms,omitempty"`
+	Terms       *Terms      `json:"terms,omitempty"`
+	FacetFilter *FilterWrap `json:"facet_filter,omitempty"`
 }
 
 type Terms struct {
@@ -0,0 +1,155 @@
+package search
+
+import (
+	"encoding/json"
+	. "github.com/araddon/gou"
+	"github.com/mattbaird/elastigo/core"
+	"testing"
+	"time"
+)
+
+func TestQueryMoreLikeThis(t *testing.T) {
+	qry := Query().MoreLikeThis(core.MLT{Fields: []string{"repository.description"}, LikeText: "javascript testing", MinTermFrequency: 1})
+	b, err := json.Marshal(qry)
+	Assert(err == nil, t, "should not have error %v", err)
+	expected := `{"more_like_this":{"fields":["repository.description"],"like_text":"javascript testing","min_term_freq":1}}`
+	Assert(string(b) == expected, t, "Should have unset options omitted %s", string(b))
+}
+
+func TestQueryBool(t *testing.T) {
+	qry := Query().Bool(
+		Bool().Must(Query().Term("actor", "bob")).
+			Should(Query().Term("repository.language", "go"), Query().Search("python")).
+			MustNot(Query().Bool(Bool().Must(Query().Term("type", "PushEvent")))).
+			MinimumShouldMatch("1").
+			Boost(2),
+	)
+	b, err := json.Marshal(qry)
+	Assert(err == nil, t, "should not have error %v", err)
+	boolJson := `{"bool":{"must":[{"term":{"actor":"bob"}}],` +
+		`"should":[{"term":{"repository.language":"go"}},{"query_string":{"query":"python"}}],` +
+		`"must_not":[{"bool":{"must":[{"term":{"type":"PushEvent"}}]}}],"minimum_should_match":"1","boost":2}}`
+	Assert(string(b) == boolJson, t, "Wrong bool query %s", string(b))
+
+	// filtered, and as the query of a search
+	b, _ = json.Marshal(Search("github").Query(Query().Bool(Bool().Must(Query().Term("actor", "bob"))).Filter(Filter().Exists("actor"))))
+	expected := `{"query":{"filtered":{"query":{"bool":{"must":[{"term":{"actor":"bob"}}]}},"filter":{"exists":{"field":"actor"}}}}}`
+	Assert(string(b) == expected, t, "Wrong search %s", string(b))
+
+	// as a query clause of filters and facet filters
+	b, _ = json.Marshal(Search("github").Filter("or", Filter().Exists("actor"), qry))
+	expected = `{"filter":{"or":[{"exists":{"field":"actor"}},{"query":` + boolJson + `}]}}`
+	Assert(string(b) == expected, t, "Wrong filter %s", string(b))
+
+	b, _ = json.Marshal(Search("github").Facet(Facet().Fields("actor").Filter("or", qry, Filter().Missing("actor"))))
+	expected = `{"facets":{"actor":{"terms":{"field":"actor"},"facet_filter":{"or":[{"query":` + boolJson + `},{"missing":{"field":"actor"}}]}}}}`
+	Assert(string(b) == expected, t, "Wrong facet filter %s", string(b))
+}
+
+func TestQueryMatch(t *testing.T) {
+	b, err := json.Marshal(Query().Match(Match("message", `unbalanced "quote`).Operator("and").Fuzziness("0.8").
+		Analyzer("standard").MinimumShouldMatch("75%").CutoffFrequency(0.001).ZeroTermsQuery("all")))
+	Assert(err == nil, t, "should not have error %v", err)
+	expected := `{"match":{"message":{"query":"unbalanced \"quote","operator":"and","analyzer":"standard","fuzziness":"0.8",` +
+		`"minimum_should_match":"75%","cutoff_frequency":0.001,"zero_terms_query":"all"}}}`
+	Assert(string(b) == expected, t, "Wrong match %s", string(b))
+
+	b, _ = json.Marshal(Query().Match(MatchPhrase("message", "this is a test").Slop(2)))
+	Assert(string(b) == `{"match_phrase":{"message":{"query":"this is a test","slop":2}}}`, t, "Wrong match_phrase %s", string(b))
+
+	b, _ = json.Marshal(Query().Match(MatchPhrasePrefix("message", "this is a te").MaxExpansions(10)))
+	Assert(string(b) == `{"match_phrase_prefix":{"message":{"query":"this is a te","max_expansions":10}}}`, t, "Wrong match_phrase_prefix %s", string(b))
+
+	// filtered, so it is wrapped as a query
+	b, _ = json.Marshal(Query().Match(MultiMatch("this is a test", "subject^3").Field("message", 0).Field("tags", 1.5)).Filter(Filter().Exists("subject")))
+	expected = `{"filtered":{"query":{"multi_match":{"query":"this is a test","fields":["subject^3","message","tags^1.5"]}},"filter":{"exists":{"field":"subject"}}}}`
+	Assert(string(b) == expected, t, "Wrong multi_match %s", string(b))
+}
+
+func TestQueryRange(t *testing.T) {
+	start := time.Date(2012, 12, 10, 15, 0, 0, 0, time.UTC)
+	b, err := json.Marshal(Query().RangeQuery(Range().Field("created_at").Gte(start).Lt("2012-12-11").Format("yyyy-MM-dd||dateOptionalTime").TimeZone("-08:00")))
+	Assert(err == nil, t, "should not have error %v", err)
+	expected := `{"range":{"created_at":{"format":"yyyy-MM-dd||dateOptionalTime","gte":"2012-12-10T15:00:00.000Z","lt":"2012-12-11","time_zone":"-08:00"}}}`
+	Assert(string(b) == expected, t, "Wrong range query %s", string(b))
+
+	// several fields and calls combine, as each range query is of one field
+	qry := Query().RangeQuery(Range().Field("repository.forks").Gt(10).Field("repository.size").Lte(2.5)).
+		RangeQuery(Range().Field("repository.forks").From("5").IncludeLower(false))
+	b, _ = json.Marshal(qry)
+	expected = `{"bool":{"must":[{"range":{"repository.forks":{"from":"5","gt":10,"include_lower":false}}},{"range":{"repository.size":{"lte":2.5}}}]}}`
+	Assert(string(b) == expected, t, "Wrong range queries %s", string(b))
+	Assert(len(qry.RangeVal) == 2, t, "Should not change the query to send it %v", qry.RangeVal)
+
+	// filters, of several fields and added to, are and-ed
+	b, _ = json.Marshal(Query().Search("add").Range(Range().Field("created_at").Gte(start).Field("repository.forks").Gt(10)).
+		Range(Filter().Exists("actor").Not()))
+	expected = `{"filtered":{"query":{"query_string":{"query":"add"}},"filter":{"and":[` +
+		`{"range":{"created_at":{"gte":"2012-12-10T15:00:00.000Z"}}},{"range":{"repository.forks":{"gt":10}}},{"not":{"exists":{"field":"actor"}}}]}}}`
+	Assert(string(b) == expected, t, "Wrong range filters %s", string(b))
+
+	// only filters, so all the docs they let through
+	b, _ = json.Marshal(Query().Range(Range().Field("repository.forks").Gt(1)))
+	expected = `{"filtered":{"query":{"match_all":{}},"filter":{"range":{"repository.forks":{"gt":1}}}}}`
+	Assert(string(b) == expected, t, "Wrong range filter only %s", string(b))
+
+	// other queries are combined with the range queries
+	b, _ = json.Marshal(Query().Term("actor", "bob").RangeQuery(Range().Field("repository.forks").Gt(1)))
+	expected = `{"bool":{"must":[{"term":{"actor":"bob"}},{"range":{"repository.forks":{"gt":1}}}]}}`
+	Assert(string(b) == expected, t, "Wrong term and range query %s", string(b))
+	b, _ = json.Marshal(Query().Match(Match("message", "test")).Term("type", "PushEvent").Term("actor", "bob").
+		Range(Filter().Exists("actor")))
+	expected = `{"filtered":{"query":{"bool":{"must":[{"term":{"actor":"bob"}},{"term":{"type":"PushEvent"}},{"match":{"message":{"query":"test"}}}]}},` +
+		`"filter":{"exists":{"field":"actor"}}}}`
+	Assert(string(b) == expected, t, "Wrong match and terms query %s", string(b))
+
+	b, _ = json.Marshal(Filter().Terms("actor", "bob").Field("repository.forks").Lt(100))
+	expected = `{"and":[{"terms":{"actor":["bob"]}},{"range":{"repository.forks":{"lt":100}}}]}`
+	Assert(string(b) == expected, t, "Wrong filter %s", string(b))
+}
+
+func TestQueryMultiTerm(t *testing.T) {
+	b, err := json.Marshal(Query().MultiTerm(Prefix("sku", "AB-12").Boost(2).Rewrite("constant_score_auto")))
+	Assert(err == nil, t, "should not have error %v", err)
+	Assert(string(b) == `{"prefix":{"sku":{"value":"AB-12","boost":2,"rewrite":"constant_score_auto"}}}`, t, "Wrong prefix %s", string(b))
+
+	b, _ = json.Marshal(Query().MultiTerm(Wildcard("code", "X?-*-2013")))
+	Assert(string(b) == `{"wildcard":{"code":{"value":"X?-*-2013"}}}`, t, "Wrong wildcard %s", string(b))
+
+	b, _ = json.Marshal(Query().MultiTerm(Regexp("zip", "[0-9]{5}").Flags("INTERSECTION|COMPLEMENT")))
+	Assert(string(b) == `{"regexp":{"zip":{"value":"[0-9]{5}","flags":"INTERSECTION|COMPLEMENT"}}}`, t, "Wrong regexp %s", string(b))
+
+	b, _ = json.Marshal(Query().MultiTerm(Fuzzy("name", "jonh").Fuzziness("2").PrefixLength(1).MaxExpansions(50)))
+	Assert(string(b) == `{"fuzzy":{"name":{"value":"jonh","fuzziness":"2","prefix_length":1,"max_expansions":50}}}`, t, "Wrong fuzzy %s", string(b))
+
+	b, _ = json.Marshal(Filter().Prefix("sku", "AB-").Regexp("zip", "[0-9]{5}", "").Regexp("code", "x.*", "NONE"))
+	expected := `{"and":[{"prefix":{"sku":"AB-"}},{"regexp":{"code":{"flags":"NONE","value":"x.*"}}},{"regexp":{"zip":{"value":"[0-9]{5}"}}}]}`
+	Assert(string(b) == expected, t, "Wrong filters %s", string(b))
+}
+
+func TestQueryJoin(t *testing.T) {
+	b, err := json.Marshal(Query().Join(Nested("items", Query().Term("items.sku", "AB-12")).ScoreMode("max")))
+	Assert(err == nil, t, "should not have error %v", err)
+	Assert(string(b) == `{"nested":{"path":"items","score_mode":"max","query":{"term":{"items.sku":"AB-12"}}}}`, t, "Wrong nested %s", string(b))
+
+	b, _ = json.Marshal(Query().Bool(Bool().
+		Must(Query().Join(HasChild("comment", Query().Match(Match("body", "late"))).ScoreMode("sum"))).
+		Should(Query().Join(TopChildren("comment", Query().Term("tag", "urgent")).ScoreMode("avg").Factor(10).IncrementalFactor(3)))))
+	expected := `{"bool":{"must":[{"has_child":{"type":"comment","score_mode":"sum","query":{"match":{"body":{"query":"late"}}}}}],` +
+		`"should":[{"top_children":{"type":"comment","score":"avg","factor":10,"incremental_factor":3,"query":{"term":{"tag":"urgent"}}}}]}}`
+	Assert(string(b) == expected, t, "Wrong child queries %s", string(b))
+
+	b, _ = json.Marshal(Query().Join(HasParent("order", Query().All()).ScoreMode("score").Boost(2)))
+	Assert(string(b) == `{"has_parent":{"parent_type":"order","score_mode":"score","boost":2,"query":{"match_all":{}}}}`, t, "Wrong has_parent %s", string(b))
+
+	b, _ = json.Marshal(Filter().Join(Nested("items", Range().Field("items.price").Gt(100))).
+		Join(HasParent("order", Filter().Terms("status", "shipped"))))
+	expected = `{"and":[{"nested":{"path":"items","filter":{"range":{"items.price":{"gt":100}}}}},` +
+		`{"has_parent":{"parent_type":"order","filter":{"terms":{"status":["shipped"]}}}}]}`
+	Assert(string(b) == expected, t, "Wrong join filters %s", string(b))
+
+	// filter trees too
+	b, _ = json.Marshal(Filter().Join(HasChild("comment", Or(Filter().Terms("author", "bob"), Filter().Exists("likes")))))
+	expected = `{"has_child":{"type":"comment","filter":{"or":[{"terms":{"author":["bob"]}},{"exists":{"field":"likes"}}]}}}`
+	Assert(string(b) == expected, t, "Wrong join filter tree %s", string(b))
+}
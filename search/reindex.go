@@ -0,0 +1,162 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/mattbaird/elastigo/core"
+	"strconv"
+	"time"
+)
+
+// A document being copied by a Reindexer, a Transform func may change any part of it
+type ReindexDoc struct {
+	Index   string
+	Type    string
+	Id      string
+	Routing string
+	Parent  string
+	Source  json.RawMessage
+}
+
+// Running counts of a reindex, documents that were skipped by the Transform func
+// are read but neither written nor failed.   Once Run returns without error,
+// Written and Failed count the docs elasticsearch acknowledged or rejected.   While
+// running, Written is of the docs sent so far, which may count retries again.
+type ReindexStats struct {
+	Read    int
+	Written int
+	Skipped int
+	Failed  int
+}
+
+func (s ReindexStats) String() string {
+	return fmt.Sprintf("<Reindex read=%d written=%d skipped=%d failed=%d />", s.Read, s.Written, s.Skipped, s.Failed)
+}
+
+// A Reindexer copies the documents of one index into another, such as after a mapping
+// change, by scanning the source index and feeding the documents to a BulkIndexor.
+// Ids, routing and parents are preserved.   The BulkIndexor may be sending to this
+// or to another cluster (see core.BulkSendTo), must already be running and only be
+// used by this Reindexer, as its Stats are the counts.   It is left running.
+//
+//    indexor := core.NewBulkIndexorErrors(10, 60)
+//    indexor.BulkSendor = core.BulkSendTo("http://new-cluster:9200")
+//    indexor.Run(done)
+//    r := NewReindexer("github", "github_v2", indexor)
+//    r.Query = Query().Term("type", "pushevent")
+//    r.Transform = func(doc *ReindexDoc) bool {
+//        return doc.Type != "GistEvent"
+//    }
+//    stats, err := r.Run(ctx)
+//    indexor.Close(ctx)
+type Reindexer struct {
+	// Optional query to select the source documents, all if nil
+	Query *QueryDsl
+	// Optional types of the source index to copy, all if empty
+	Types []string
+	// Number of docs per shard per scroll page, defaults to 100
+	PageSize int
+	// Keep alive of the scroll between pages, defaults to "5m"
+	KeepAlive string
+	// Optional func called for each document before it is written, it may modify
+	// the document, returning false skips it
+	Transform func(doc *ReindexDoc) bool
+	// Optional func called every PageSize documents, and when done, with the counts so far
+	Progress func(stats ReindexStats)
+
+	srcIndex  string
+	destIndex string
+	indexor   *core.BulkIndexor
+}
+
+func NewReindexer(srcIndex, destIndex string, indexor *core.BulkIndexor) *Reindexer {
+	return &Reindexer{srcIndex: srcIndex, destIndex: destIndex, indexor: indexor, PageSize: 100, KeepAlive: "5m"}
+}
+
+// Run the reindex, blocking until all documents have been sent by the BulkIndexor,
+// so the counts are final, or the context is cancelled.
+func (r *Reindexer) Run(ctx context.Context) (ReindexStats, error) {
+	var stats ReindexStats
+	pageSize := r.PageSize
+	if pageSize <= 0 {
+		pageSize = 100
+	}
+	qry := Search(r.srcIndex).Scan().Size(strconv.Itoa(pageSize)).Fields("_source", "_routing", "_parent")
+	for _, t := range r.Types {
+		qry.Type(t)
+	}
+	if r.Query != nil {
+		qry.Query(r.Query)
+	}
+
+	// the docs that failed for good are the indexor's Errors
+	before := r.indexor.Stats()
+	indexed, indexFailed := 0, 0
+	counts := func(done bool) ReindexStats {
+		bulkStats := r.indexor.Stats()
+		failed := int(bulkStats.Errors - before.Errors)
+		stats.Failed = indexFailed + failed
+		if done {
+			stats.Written = indexed - failed
+		} else if sent := int(bulkStats.Docs-before.Docs) - failed; sent < indexed {
+			stats.Written = sent
+		} else {
+			stats.Written = indexed
+		}
+		return stats
+	}
+
+	scroller := NewScroller(ctx, qry, r.KeepAlive)
+	defer scroller.Close()
+
+	for scroller.Next() {
+		hit := scroller.Hit()
+		stats.Read++
+		doc := ReindexDoc{Index: r.destIndex, Type: hit.Type, Id: hit.Id, Source: hit.Source,
+			Routing: hit.StringField("_routing"), Parent: hit.StringField("_parent")}
+		if r.Transform != nil && !r.Transform(&doc) {
+			stats.Skipped++
+		} else if err := r.indexor.IndexRouted(doc.Index, doc.Type, doc.Id, doc.Routing, doc.Parent, nil, []byte(doc.Source)); err != nil {
+			indexFailed++
+		} else {
+			indexed++
+		}
+		if r.Progress != nil && stats.Read%pageSize == 0 {
+			r.Progress(counts(false))
+		}
+	}
+	if err := scroller.Err(); err != nil {
+		return counts(false), err
+	}
+	if err := r.delivered(ctx); err != nil {
+		return counts(false), err
+	}
+	if spooled := r.indexor.Stats().Spooled; spooled > 0 {
+		// their docs are neither written nor failed yet
+		return counts(false), fmt.Errorf("reindex left %d buffers in the bulk spool", spooled)
+	}
+	counts(true)
+	if r.Progress != nil {
+		r.Progress(stats)
+	}
+	return stats, nil
+}
+
+// Wait until the docs handed to the indexor have been sent, or failed, flushing the
+// partly filled buffers as the docs reach them
+func (r *Reindexer) delivered(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		r.indexor.Flush()
+		if r.indexor.Stats().InFlightBytes == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
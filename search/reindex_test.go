@@ -0,0 +1,54 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	. "github.com/araddon/gou"
+	"github.com/mattbaird/elastigo/core"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReindex(t *testing.T) {
+	cleared := ""
+	defer scrollServer(t, &cleared)()
+
+	body := ""
+	indexor := core.NewBulkIndexor(1)
+	indexor.BulkSendor = func(buf *bytes.Buffer) error {
+		body += buf.String()
+		// the second doc is rejected
+		return &core.BulkItemsError{Response: core.BulkResponse{Items: []core.BulkItemResult{
+			{Action: "index", Id: "1", Ok: true},
+			{Action: "index", Id: "3", Status: 400, Error: "MapperParsingException[failed to parse]"},
+		}}}
+	}
+	sends := 0
+	indexor.AfterSend = func(result core.BulkSendResult, took time.Duration) {
+		sends++
+	}
+	indexor.Run(make(chan bool))
+
+	r := NewReindexer("github", "github_v2", indexor)
+	r.KeepAlive = "1m"
+	r.Transform = func(doc *ReindexDoc) bool {
+		return doc.Id != "2"
+	}
+	progressCt := 0
+	r.Progress = func(stats ReindexStats) {
+		progressCt++
+	}
+	stats, err := r.Run(context.Background())
+	Assert(err == nil, t, "Should not have error %v", err)
+	Assert(stats.Read == 3 && stats.Written == 1 && stats.Skipped == 1 && stats.Failed == 1, t, "Wrong counts %v", stats)
+	Assert(progressCt == 1, t, "Should have reported progress once %v", progressCt)
+	Assert(sends == 1, t, "Should still call the indexors hook %v", sends)
+	Assert(indexor.Stats().InFlightBytes == 0, t, "Should have waited for the docs to be sent %v", indexor.Stats())
+	Assert(indexor.Close(context.Background()) != nil, t, "Should leave the indexor to close, with its failed doc")
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	Assert(len(lines) == 4, t, "Should have sent 2 docs %v", body)
+	Assert(lines[0] == `{"index":{"_index":"github_v2","_type":"user","_id":"1"}}`, t, "Wrong action %v", lines[0])
+	Assert(lines[1] == `{"name":"bob"}`, t, "Should copy the source %v", lines[1])
+	Assert(lines[2] == `{"index":{"_index":"github_v2","_type":"comment","_id":"3","_parent":"1"}}`, t, "Should keep parent %v", lines[2])
+}
@@ -0,0 +1,175 @@
+package search
+
+/*
+	"query": {
+	  "function_score": {
+	    "query": {"match": {"title": {"query": "boots"}}},
+	    "functions": [
+	      {"filter": {"terms": {"promoted": [true]}}, "boost_factor": 3},
+	      {"script_score": {"script": "log(2 + doc['sales'].value)"}},
+	      {"gauss": {"published": {"origin": "2013-09-17", "scale": "10d", "decay": 0.5}}}
+	    ],
+	    "score_mode": "multiply",
+	    "boost_mode": "sum"
+	  }
+	}
+*/
+
+// A function_score query scores the docs of a query with functions, each applying
+// to all the docs or to those matching its filter.   A nil @query is all docs.
+//
+//    Query().FunctionScore(
+//        FunctionScore(Query().Match(Match("title", "boots"))).
+//            Add(BoostFactor(3).Filter(Filter().Terms("promoted", true))).
+//            Add(ScriptScore("log(2 + doc['sales'].value)", nil)).
+//            Add(Gauss("published", "2013-09-17", "10d").Decay(0.5)).
+//            ScoreMode("multiply").BoostMode("sum"),
+//    )
+func FunctionScore(query *QueryDsl) *FunctionScoreQuery {
+	return &FunctionScoreQuery{QueryVal: query}
+}
+
+type FunctionScoreQuery struct {
+	QueryVal     *QueryDsl        `json:"query,omitempty"`
+	FunctionsVal []*ScoreFunction `json:"functions,omitempty"`
+	ScoreModeVal string           `json:"score_mode,omitempty"`
+	BoostModeVal string           `json:"boost_mode,omitempty"`
+	MaxBoostVal  float64          `json:"max_boost,omitempty"`
+	BoostVal     float64          `json:"boost,omitempty"`
+}
+
+func (fs *FunctionScoreQuery) Add(functions ...*ScoreFunction) *FunctionScoreQuery {
+	fs.FunctionsVal = append(fs.FunctionsVal, functions...)
+	return fs
+}
+
+// How the scores of the functions combine [multiply, sum, avg, first, max, min]
+func (fs *FunctionScoreQuery) ScoreMode(mode string) *FunctionScoreQuery {
+	fs.ScoreModeVal = mode
+	return fs
+}
+
+// How the functions score combines with the query score [multiply, replace, sum,
+// avg, max, min]
+func (fs *FunctionScoreQuery) BoostMode(mode string) *FunctionScoreQuery {
+	fs.BoostModeVal = mode
+	return fs
+}
+
+// The most the functions score can be
+func (fs *FunctionScoreQuery) MaxBoost(max float64) *FunctionScoreQuery {
+	fs.MaxBoostVal = max
+	return fs
+}
+
+func (fs *FunctionScoreQuery) Boost(boost float64) *FunctionScoreQuery {
+	fs.BoostVal = boost
+	return fs
+}
+
+// A function of a function_score, see ScriptScore, BoostFactor, RandomScore and
+// Gauss
+type ScoreFunction struct {
+	decay *DecayFunction
+
+	FilterVal      interface{}               `json:"filter,omitempty"`
+	ScriptScoreVal *ScoreScript              `json:"script_score,omitempty"`
+	BoostFactorVal float64                   `json:"boost_factor,omitempty"`
+	RandomScoreVal *RandomSeed               `json:"random_score,omitempty"`
+	GaussVal       map[string]*DecayFunction `json:"gauss,omitempty"`
+	ExpVal         map[string]*DecayFunction `json:"exp,omitempty"`
+	LinearVal      map[string]*DecayFunction `json:"linear,omitempty"`
+}
+
+type ScoreScript struct {
+	Script string                 `json:"script"`
+	Lang   string                 `json:"lang,omitempty"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+type RandomSeed struct {
+	Seed int64 `json:"seed"`
+}
+
+// The decay of the score with the distance of a field from the origin, see Gauss
+type DecayFunction struct {
+	Origin interface{} `json:"origin"`
+	Scale  interface{} `json:"scale"`
+	Offset interface{} `json:"offset,omitempty"`
+	Decay  float64     `json:"decay,omitempty"`
+}
+
+// Score with a script, which has the @params as variables
+//
+//    ScriptScore("_score * doc['popularity'].value / pow(param1, 2)", map[string]interface{}{"param1": 2})
+func ScriptScore(script string, params map[string]interface{}) *ScoreFunction {
+	return &ScoreFunction{ScriptScoreVal: &ScoreScript{Script: script, Params: params}}
+}
+
+// Multiply the score by @factor
+func BoostFactor(factor float64) *ScoreFunction {
+	return &ScoreFunction{BoostFactorVal: factor}
+}
+
+// A random score, the same for a doc as long as the @seed is, so pages of results
+// stay consistent for a user
+func RandomScore(seed int64) *ScoreFunction {
+	return &ScoreFunction{RandomScoreVal: &RandomSeed{seed}}
+}
+
+// Decay the score the further the @field is from @origin, following a normal curve
+// where the score is Decay (default 0.5) at Offset + @scale.   The field may be
+//    numeric: Gauss("price", 20, 10)
+//    a date: Gauss("published", "2013-09-17", "10d"), or "now"
+//    a geo point: Gauss("location", LatLon(11, 12), "2km")
+func Gauss(field string, origin, scale interface{}) *ScoreFunction {
+	decay := &DecayFunction{Origin: origin, Scale: scale}
+	return &ScoreFunction{decay: decay, GaussVal: map[string]*DecayFunction{field: decay}}
+}
+
+// Decay the score exponentially, see Gauss
+func Exp(field string, origin, scale interface{}) *ScoreFunction {
+	decay := &DecayFunction{Origin: origin, Scale: scale}
+	return &ScoreFunction{decay: decay, ExpVal: map[string]*DecayFunction{field: decay}}
+}
+
+// Decay the score linearly, reaching 0 at twice the scale, see Gauss
+func Linear(field string, origin, scale interface{}) *ScoreFunction {
+	decay := &DecayFunction{Origin: origin, Scale: scale}
+	return &ScoreFunction{decay: decay, LinearVal: map[string]*DecayFunction{field: decay}}
+}
+
+// Only apply the function to the docs matching @filter, a *FilterOp, a tree of
+// them (And, Or, Not, BoolFilter) or a *QueryDsl
+//
+//    BoostFactor(2).Filter(Or(Filter().Terms("promoted", true), Range().Field("sales").Gt(100)))
+func (f *ScoreFunction) Filter(filter interface{}) *ScoreFunction {
+	if fc, ok := filterValue(filter); ok {
+		f.FilterVal = fc
+	}
+	return f
+}
+
+// The script language of a ScriptScore, default mvel
+func (f *ScoreFunction) Lang(lang string) *ScoreFunction {
+	if f.ScriptScoreVal != nil {
+		f.ScriptScoreVal.Lang = lang
+	}
+	return f
+}
+
+// The distance from the origin within which a decay function does not decay
+func (f *ScoreFunction) Offset(offset interface{}) *ScoreFunction {
+	if f.decay != nil {
+		f.decay.Offset = offset
+	}
+	return f
+}
+
+// The score of a decay function at the scale distance, default 0.5
+func (f *ScoreFunction) Decay(decay float64) *ScoreFunction {
+	if f.decay != nil {
+		f.decay.Decay = decay
+	}
+	return f
+}
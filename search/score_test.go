@@ -0,0 +1,44 @@
+package search
+
+import (
+	"encoding/json"
+	. "github.com/araddon/gou"
+	"testing"
+)
+
+func TestFunctionScore(t *testing.T) {
+	qry := Query().FunctionScore(
+		FunctionScore(Query().Match(Match("title", "boots"))).
+			Add(BoostFactor(3).Filter(Filter().Terms("promoted", true))).
+			Add(ScriptScore("_score * doc['sales'].value / pow(p, 2)", map[string]interface{}{"p": 2}).Lang("mvel")).
+			Add(RandomScore(42)).
+			ScoreMode("sum").BoostMode("multiply").MaxBoost(10),
+	)
+	b, err := json.Marshal(qry)
+	Assert(err == nil, t, "should not have error %v", err)
+	expected := `{"function_score":{"query":{"match":{"title":{"query":"boots"}}},"functions":[` +
+		`{"filter":{"terms":{"promoted":[true]}},"boost_factor":3},` +
+		`{"script_score":{"script":"_score * doc['sales'].value / pow(p, 2)","lang":"mvel","params":{"p":2}}},` +
+		`{"random_score":{"seed":42}}],"score_mode":"sum","boost_mode":"multiply","max_boost":10}}`
+	Assert(string(b) == expected, t, "Wrong function_score %s", string(b))
+
+	// decays, within a search
+	b, _ = json.Marshal(Search("github").Query(Query().FunctionScore(FunctionScore(nil).
+		Add(Gauss("published", "now", "10d").Offset("1d").Decay(0.3)).
+		Add(Exp("location", "11,12", "2km")).
+		Add(Linear("price", 20, 10).Filter(Range().Field("price").Gt(0))))))
+	expected = `{"query":{"function_score":{"functions":[` +
+		`{"gauss":{"published":{"origin":"now","scale":"10d","offset":"1d","decay":0.3}}},` +
+		`{"exp":{"location":{"origin":"11,12","scale":"2km"}}},` +
+		`{"filter":{"range":{"price":{"gt":0}}},"linear":{"price":{"origin":20,"scale":10}}}]}}}`
+	Assert(string(b) == expected, t, "Wrong decay functions %s", string(b))
+
+	// filter trees, and queries
+	b, _ = json.Marshal(FunctionScore(nil).
+		Add(BoostFactor(2).Filter(Or(Filter().Terms("promoted", true), Range().Field("sales").Gt(100)))).
+		Add(BoostFactor(3).Filter(Query().Term("tag", "new"))))
+	expected = `{"functions":[` +
+		`{"filter":{"or":[{"terms":{"promoted":[true]}},{"range":{"sales":{"gt":100}}}]},"boost_factor":2},` +
+		`{"filter":{"query":{"term":{"tag":"new"}}},"boost_factor":3}]}`
+	Assert(string(b) == expected, t, "Wrong filtered functions %s", string(b))
+}
@@ -0,0 +1,138 @@
+package search
+
+import (
+	"context"
+	"github.com/mattbaird/elastigo/core"
+	"net/url"
+)
+
+// A Scroller iterates over every hit of a search, lazily fetching a page at a time
+// with the scroll api and renewing the keep alive on each fetch.   The scroll is
+// cleared on the server once the hits are exhausted, on error or cancellation of
+// the context, or on Close.   The context is checked before each fetch, a page
+// being fetched when it is cancelled is not interrupted.
+//
+//    s := NewScroller(ctx, Search("github").Scan().Size("100").Query(Query().All()), "1m")
+//    defer s.Close()
+//    for s.Next() {
+//        log.Println(s.Hit().Id)
+//    }
+//    if err := s.Err(); err != nil {
+//        log.Println(err)
+//    }
+type Scroller struct {
+	ctx       context.Context
+	search    *SearchDsl
+	keepAlive string
+	scrollId  string
+	total     int
+	hits      []core.Hit
+	pos       int
+	started   bool
+	done      bool
+	err       error
+}
+
+// Create a new Scroller over the results of this search, nothing is fetched until
+// the first call to Next.
+//    @keepAlive is how long to keep the search context alive between fetches, "1m"
+func NewScroller(ctx context.Context, s *SearchDsl, keepAlive string) *Scroller {
+	return &Scroller{ctx: ctx, search: s, keepAlive: keepAlive, pos: -1}
+}
+
+// Advance to the next hit, fetching the next page if needed.   Returns false once
+// all hits have been read or an error occurred, see Err
+func (s *Scroller) Next() bool {
+	if s.done {
+		return false
+	}
+	s.pos++
+	for s.pos >= len(s.hits) {
+		if err := s.ctx.Err(); err != nil {
+			s.finish(err)
+			return false
+		}
+		if !s.fetch() {
+			return false
+		}
+	}
+	return true
+}
+
+// The current hit
+func (s *Scroller) Hit() *core.Hit {
+	if s.pos < 0 || s.pos >= len(s.hits) {
+		return nil
+	}
+	return &s.hits[s.pos]
+}
+
+// The total number of hits for this search, known after the first call to Next
+func (s *Scroller) Total() int {
+	return s.total
+}
+
+// The error, if any, that stopped the iteration
+func (s *Scroller) Err() error {
+	return s.err
+}
+
+// Stop iterating and clear the scroll on the server, safe to call more than once
+func (s *Scroller) Close() error {
+	s.done = true
+	if len(s.scrollId) == 0 {
+		return nil
+	}
+	err := core.ClearScroll(s.scrollId)
+	s.scrollId = ""
+	return err
+}
+
+// fetch the next page, the initial search or a scroll request
+func (s *Scroller) fetch() bool {
+	var out *core.SearchResult
+	var err error
+	first := !s.started
+	if first {
+		s.started = true
+		out, err = s.scrollSearch().Result()
+	} else {
+		var result core.SearchResult
+		result, err = core.Scroll(false, s.scrollId, s.keepAlive)
+		out = &result
+	}
+	if err != nil {
+		s.finish(err)
+		return false
+	}
+	if len(out.ScrollId) > 0 {
+		s.scrollId = out.ScrollId
+	}
+	s.total = out.Hits.Total
+	s.hits = out.Hits.Hits
+	s.pos = 0
+	// the first page of a scan has no hits, only a scroll id, otherwise
+	// an empty page means we are done
+	if len(s.hits) == 0 && !(first && s.search.args.Get("search_type") == "scan") {
+		s.finish(nil)
+		return false
+	}
+	return true
+}
+
+// a copy of the search with the scroll set, leaving the callers search as it was
+func (s *Scroller) scrollSearch() *SearchDsl {
+	search := *s.search
+	search.args = url.Values{}
+	for name, vals := range s.search.args {
+		search.args[name] = append([]string(nil), vals...)
+	}
+	return search.Scroll(s.keepAlive)
+}
+
+func (s *Scroller) finish(err error) {
+	s.err = err
+	if clearErr := s.Close(); s.err == nil {
+		s.err = clearErr
+	}
+}
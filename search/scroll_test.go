@@ -0,0 +1,71 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	. "github.com/araddon/gou"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+// a fake elasticsearch serving a scan of 3 docs, 2 per page
+func scrollServer(t *testing.T, cleared *string) func() {
+	pages := map[string]string{
+		"s1": `{"_scroll_id":"s2","hits":{"total":3,"hits":[
+			{"_index":"github","_type":"user","_id":"1","_source":{"name":"bob"}},
+			{"_index":"github","_type":"user","_id":"2","_source":{"name":"jane"},"fields":{"_routing":"r2"}}]}}`,
+		"s2": `{"_scroll_id":"s3","hits":{"total":3,"hits":[
+			{"_index":"github","_type":"comment","_id":"3","_source":{"text":"hi"},"fields":{"_parent":"1"}}]}}`,
+		"s3": `{"_scroll_id":"s4","hits":{"total":3,"hits":[]}}`,
+	}
+	return fakeServer(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch {
+		case r.URL.Path == "/github/_search":
+			Assert(r.URL.Query().Get("search_type") == "scan", t, "Should be a scan %v", r.URL)
+			Assert(r.URL.Query().Get("scroll") == "1m", t, "Should have keep alive %v", r.URL)
+			fmt.Fprint(w, `{"_scroll_id":"s1","hits":{"total":3,"hits":[]}}`)
+		case r.URL.Path == "/_search/scroll" && r.Method == "DELETE":
+			*cleared = string(body)
+			fmt.Fprint(w, `{"ok":true}`)
+		case r.URL.Path == "/_search/scroll":
+			Assert(r.URL.Query().Get("scroll") == "1m", t, "Should renew keep alive %v", r.URL)
+			fmt.Fprint(w, pages[string(body)])
+		default:
+			w.WriteHeader(404)
+		}
+	})
+}
+
+func TestScrollerScan(t *testing.T) {
+	cleared := ""
+	defer scrollServer(t, &cleared)()
+
+	search := Search("github").Scan().Size("2")
+	s := NewScroller(context.Background(), search, "1m")
+	ids := make([]string, 0)
+	for s.Next() {
+		ids = append(ids, s.Hit().Id)
+	}
+	Assert(s.Err() == nil, t, "Should not have error %v", s.Err())
+	Assert(len(ids) == 3 && ids[0] == "1" && ids[2] == "3", t, "Should have read 3 docs %v", ids)
+	Assert(s.Total() == 3, t, "Should have total=3 but was %v", s.Total())
+	Assert(cleared == "s4", t, "Should have cleared the scroll %v", cleared)
+	Assert(!s.Next(), t, "Should stay done")
+	Assert(search.args.Get("scroll") == "", t, "Should not change the search %v", search.args)
+}
+
+func TestScrollerCancel(t *testing.T) {
+	cleared := ""
+	defer scrollServer(t, &cleared)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewScroller(ctx, Search("github").Scan().Size("2"), "1m")
+	Assert(s.Next() && s.Hit().Id == "1", t, "Should have first doc")
+	cancel()
+	for s.Next() {
+	}
+	Assert(s.Err() == context.Canceled, t, "Should have been canceled %v", s.Err())
+	Assert(cleared == "s2", t, "Should have cleared the scroll on cancel %v", cleared)
+}
@@ -37,6 +37,7 @@ type SearchDsl struct {
 	FromVal   int         `json:"from,omitempty"`
 	SizeVal   int         `json:"size,omitempty"`
 	Index     string      `json:"-"`
+	FieldsVal []string    `json:"fields,omitempty"`
 	FacetVal  *FacetDsl   `json:"facets,omitempty"`
 	QueryVal  *QueryDsl   `json:"query,omitempty"`
 	SortBody  []*SortDsl  `json:"sort,omitempty"`
@@ -67,6 +68,35 @@ func (s *SearchDsl) Result() (*core.SearchResult, error) {
 	return &retval, jsonErr
 }
 
+// Validate the query of this search against the server without executing it, useful
+// to check a user supplied query before running an expensive search.  The query
+// and filters are validated, as a filtered query, a search without a query is
+// validated as match_all.
+//
+//    v, err := Search("github").Query(Query().Search("actor:a* AND")).Validate()
+//    if err == nil && !v.Valid {
+//        log.Println(v.Errors())
+//    }
+func (s *SearchDsl) Validate() (*core.Validation, error) {
+	var qry interface{} = s.QueryVal
+	if s.QueryVal == nil {
+		qry = Query().All()
+	}
+	if s.FilterVal != nil {
+		qry = map[string]interface{}{"filtered": map[string]interface{}{"query": qry, "filter": s.FilterVal}}
+	}
+	if core.DebugRequests {
+		qb, _ := json.MarshalIndent(qry, "  ", "  ")
+		log.Println(string(qb))
+	}
+	v, err := core.ValidateQuery(false, s.Index, strings.Join(s.types, ","), qry, true)
+	if err != nil {
+		Logf(ERROR, "%v", err)
+		return nil, err
+	}
+	return &v, nil
+}
+
 func (s *SearchDsl) url() string {
 	url := fmt.Sprintf("/%s%s/_search?%s", s.Index, s.getType(), s.args.Encode())
 	return url
@@ -106,11 +136,41 @@ func (s *SearchDsl) Search(srch string) *SearchDsl {
 	return s
 }
 
+// The search type to execute [query_then_fetch, query_and_fetch, dfs_query_then_fetch,
+// dfs_query_and_fetch, count, scan]
+func (s *SearchDsl) SearchType(searchType string) *SearchDsl {
+	s.args.Set("search_type", searchType)
+	return s
+}
+
+// Use the scan search type, which does no scoring or sorting and is the efficient way
+// to scroll over a large result set.  Size is then the number of hits per shard per
+// page, see NewScroller.
+func (s *SearchDsl) Scan() *SearchDsl {
+	return s.SearchType("scan")
+}
+
+// Keep the search context alive for this long (such as "1m") and return a scroll id
+// for fetching further pages
+func (s *SearchDsl) Scroll(duration string) *SearchDsl {
+	s.args.Set("scroll", duration)
+	return s
+}
+
 func (s *SearchDsl) Size(size string) *SearchDsl {
 	s.args.Set("size", size)
 	return s
 }
 
+// The fields of each hit to return, use "_source" to include the source along with
+// metadata fields such as "_routing" and "_parent"
+//
+//    Search("github").Fields("_source", "_routing", "_parent")
+func (s *SearchDsl) Fields(fields ...string) *SearchDsl {
+	s.FieldsVal = append(s.FieldsVal, fields...)
+	return s
+}
+
 func (s *SearchDsl) Facet(f *FacetDsl) *SearchDsl {
 	s.FacetVal = f
 	return s
@@ -145,7 +205,10 @@ func (s *SearchDsl) Query(q *QueryDsl) *SearchDsl {
 }
 
 // Add Filter Clause with optional Boolean Clause.  This accepts n number of
-// filter clauses.  If more than one, and missing Boolean Clause it assumes "and"
+// filter clauses.  If more than one, and missing Boolean Clause it assumes "and".
+// A Boolean Clause only applies to the clauses after it in the same call, the
+// filters of separate calls are and-ed.   For anything more nested, pass And, Or,
+// Not and BoolFilter nodes.
 //
 //     qry := Search("github").Filter(
 //         Filter().Exists("repository.name"),
@@ -166,6 +229,15 @@ func (s *SearchDsl) Query(q *QueryDsl) *SearchDsl {
 //     qry := Search("github")
 //     qry.Filter(Filter().Exists("repository.name"))
 //     qry.Filter(Filter().Terms("repository.has_wiki", true))
+//
+//     // location is portland and (has a wiki or not has_downloads)
+//     qry := Search("github").Filter(
+//         Filter().Terms("actor_attributes.location", "portland"),
+//         Or(
+//             Filter().Terms("repository.has_wiki", true),
+//             Not(Filter().Terms("repository.has_downloads", true)),
+//         ),
+//     )
 func (s *SearchDsl) Filter(fl ...interface{}) *SearchDsl {
 	if s.FilterVal == nil {
 		s.FilterVal = NewFilterWrap()
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	. "github.com/araddon/gou"
 	"github.com/mattbaird/elastigo/core"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"testing"
 )
 
@@ -305,3 +307,25 @@ func TestSearchSortOrder(t *testing.T) {
 	Assert(h3.Int("repository.watchers") == 8659, t, "Should have 8659 watchers= %v", h3.Int("repository.watchers"))
 
 }
+
+func TestSearchValidate(t *testing.T) {
+	var url, body string
+	defer fakeServer(func(w http.ResponseWriter, r *http.Request) {
+		by, _ := ioutil.ReadAll(r.Body)
+		url, body = r.URL.RequestURI(), string(by)
+		fmt.Fprint(w, `{"valid":false,"_shards":{"total":1,"successful":1,"failed":0},
+			"explanations":[{"index":"github","valid":false,"error":"bad range"}]}`)
+	})()
+
+	v, err := Search("github").Type("PushEvent").Query(Query().Search("add")).Filter(
+		Range().Field("created_at").Gte("yesterday"),
+	).Validate()
+	Assert(err == nil, t, "should not have error %v", err)
+	Assert(url == "/github/PushEvent/_validate/query?explain=true", t, "Wrong url %s", url)
+	expected := `{"filtered":{"filter":{"range":{"created_at":{"gte":"yesterday"}}},"query":{"query_string":{"query":"add"}}}}`
+	Assert(body == expected, t, "Should validate the filters too %s", body)
+	Assert(!v.Valid && len(v.Errors()) == 1 && v.Errors()[0] == "[github] bad range", t, "Wrong validation %v", v)
+
+	Search("github").Validate()
+	Assert(body == `{"match_all":{}}`, t, "Should validate as match_all %s", body)
+}
@@ -17,8 +17,31 @@ func Sort(field string) *SortDsl {
 
 type SortBody []interface{}
 type SortDsl struct {
-	Name   string
-	IsDesc bool
+	Name      string
+	IsDesc    bool
+	GeoPoints []GeoPoint // sort by the distance of the Name geo point field from these
+	UnitVal   string     // of the distance
+}
+
+// Sort by the distance of the geo point field from @points (the closest of them),
+// nearest first unless Desc.   The distance, in Unit (default km), is in the Sort
+// values of the hits:
+//
+//     out, err := Search("stores").Filter(
+//         Filter().GeoDistance("location", here, "10km"),
+//     ).Sort(
+//         Sort("location").GeoDistance(here).Unit("km"),
+//     ).Result()
+//     km := out.Hits.Hits[0].Sort[0].(float64)
+func (s *SortDsl) GeoDistance(points ...GeoPoint) *SortDsl {
+	s.GeoPoints = append(s.GeoPoints, points...)
+	return s
+}
+
+// The unit of a GeoDistance sort [km, mi, m, yd, ft, in, cm, mm, nmi]
+func (s *SortDsl) Unit(unit string) *SortDsl {
+	s.UnitVal = unit
+	return s
 }
 
 func (s *SortDsl) Desc() *SortDsl {
@@ -31,6 +54,21 @@ func (s *SortDsl) Asc() *SortDsl {
 }
 
 func (s *SortDsl) MarshalJSON() ([]byte, error) {
+	if len(s.GeoPoints) > 0 {
+		geo := map[string]interface{}{"order": "asc"}
+		if s.IsDesc {
+			geo["order"] = "desc"
+		}
+		if len(s.GeoPoints) == 1 {
+			geo[s.Name] = s.GeoPoints[0]
+		} else {
+			geo[s.Name] = s.GeoPoints
+		}
+		if s.UnitVal != "" {
+			geo["unit"] = s.UnitVal
+		}
+		return json.Marshal(map[string]interface{}{"_geo_distance": geo})
+	}
 	if s.IsDesc {
 		return json.Marshal(map[string]string{s.Name: "desc"})
 	}
@@ -6,6 +6,10 @@ import (
 	"github.com/mattbaird/elastigo/api"
 	"github.com/mattbaird/elastigo/core"
 	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 )
 
@@ -38,3 +42,16 @@ func InitTests(startIndexor bool) {
 		api.Domain = *eshost
 	}
 }
+
+// point the api at a fake elasticsearch, returns the func to restore it
+func fakeServer(handler http.HandlerFunc) func() {
+	ts := httptest.NewServer(handler)
+	addr, _ := url.Parse(ts.URL)
+	host, port, _ := net.SplitHostPort(addr.Host)
+	domain, origPort := api.Domain, api.Port
+	api.Domain, api.Port = host, port
+	return func() {
+		api.Domain, api.Port = domain, origPort
+		ts.Close()
+	}
+}